@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type allureResult struct {
+	UUID          string             `json:"uuid"`
+	HistoryID     string             `json:"historyId"`
+	Name          string             `json:"name"`
+	FullName      string             `json:"fullName"`
+	Status        string             `json:"status"`
+	StatusDetails *allureDetails     `json:"statusDetails,omitempty"`
+	Stage         string             `json:"stage"`
+	Start         int64              `json:"start,omitempty"`
+	Stop          int64              `json:"stop,omitempty"`
+	Labels        []allureLabel      `json:"labels"`
+	Parameters    []allureLabel      `json:"parameters,omitempty"`
+	Attachments   []allureAttachment `json:"attachments,omitempty"`
+}
+
+type allureDetails struct {
+	Message string `json:"message,omitempty"`
+	Trace   string `json:"trace,omitempty"`
+}
+
+type allureLabel struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type allureAttachment struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Type   string `json:"type,omitempty"`
+}
+
+func allureStatus(result string) string {
+	switch result {
+	case "PASSED", "WARNED":
+		return "passed"
+	case "FAILED":
+		return "failed"
+	case "ERRORED":
+		return "broken"
+	case "SKIPPED", "STOPPED", "PENDING":
+		return "skipped"
+	}
+	return "unknown"
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	failOnErr(err, "error generating uuid")
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func allureTime(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// copyAllureAttachment copies a downloaded artifact next to the results,
+// Allure only looks for attachments in the results directory
+func copyAllureAttachment(artifact *artifactNode, dir string) (allureAttachment, error) {
+
+	ext := filepath.Ext(artifact.Path)
+	source := newUUID() + "-attachment" + ext
+
+	in, err := os.Open(artifact.Path)
+	if err != nil {
+		return allureAttachment{}, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(filepath.Join(dir, source))
+	if err != nil {
+		return allureAttachment{}, err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return allureAttachment{}, err
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" || strings.HasPrefix(mimeType, "text/") {
+		mimeType = "text/plain"
+	}
+
+	return allureAttachment{
+		Name:   aws.StringValue(artifact.Artifact.Name),
+		Source: source,
+		Type:   mimeType,
+	}, nil
+}
+
+/* Write a run as Allure result files */
+func writeAllureResults(tree *runTree, dir string) error {
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range tree.Jobs {
+		device := jobFriendlyName(job.Job)
+		labels := []allureLabel{
+			{Name: "parentSuite", Value: device},
+			{Name: "host", Value: aws.StringValue(job.Job.Name)},
+			{Name: "framework", Value: "devicefarm"},
+		}
+		parameters := []allureLabel{
+			{Name: "device", Value: aws.StringValue(job.Job.Name)},
+		}
+		if job.Job.Device != nil {
+			labels = append(labels,
+				allureLabel{Name: "os", Value: aws.StringValue(job.Job.Device.Os)},
+				allureLabel{Name: "platform", Value: aws.StringValue(job.Job.Device.Platform)},
+			)
+			parameters = append(parameters, allureLabel{Name: "os", Value: aws.StringValue(job.Job.Device.Os)})
+		}
+
+		for _, suite := range job.Suites {
+			suiteName := aws.StringValue(suite.Suite.Name)
+
+			for _, test := range suite.Tests {
+				name := aws.StringValue(test.Test.Name)
+				fullName := strings.Join([]string{device, suiteName, name}, " / ")
+
+				result := allureResult{
+					UUID:       newUUID(),
+					HistoryID:  fmt.Sprintf("%x", md5.Sum([]byte(fullName))),
+					Name:       name,
+					FullName:   fullName,
+					Status:     allureStatus(aws.StringValue(test.Test.Result)),
+					Stage:      "finished",
+					Start:      allureTime(test.Test.Started),
+					Stop:       allureTime(test.Test.Stopped),
+					Labels:     append([]allureLabel{{Name: "suite", Value: suiteName}}, labels...),
+					Parameters: parameters,
+				}
+
+				if message := aws.StringValue(test.Test.Message); message != "" {
+					result.StatusDetails = &allureDetails{Message: message}
+				}
+
+				for _, artifact := range test.Artifacts {
+					if artifact.Path == "" {
+						continue
+					}
+					attachment, err := copyAllureAttachment(artifact, dir)
+					if err != nil {
+						return err
+					}
+					result.Attachments = append(result.Attachments, attachment)
+				}
+
+				data, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return err
+				}
+
+				err = ioutil.WriteFile(filepath.Join(dir, result.UUID+"-result.json"), data, 0666)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	environment := fmt.Sprintf("Run=%s\nPlatform=%s\nType=%s\nArn=%s\n",
+		aws.StringValue(tree.Run.Name), aws.StringValue(tree.Run.Platform), aws.StringValue(tree.Run.Type), aws.StringValue(tree.Run.Arn))
+
+	return ioutil.WriteFile(filepath.Join(dir, "environment.properties"), []byte(environment), 0666)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"path"
+	"strings"
+)
+
+// An artifactFilter selects artifacts by category (LOG,FILE,SCREENSHOT),
+// artifact type (VIDEO,DEVICE_LOG,CUSTOMER_ARTIFACT,...) and file name
+type artifactFilter struct {
+	Types   []string
+	Include []string
+	Exclude []string
+}
+
+// splitList flattens repeated and comma separated flag values
+func splitList(values []string) []string {
+
+	list := []string{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part != "" {
+				list = append(list, part)
+			}
+		}
+	}
+
+	return list
+}
+
+func newArtifactFilter(types []string, include []string, exclude []string) artifactFilter {
+
+	filter := artifactFilter{
+		Include: splitList(include),
+		Exclude: splitList(exclude),
+	}
+
+	for _, artifactType := range splitList(types) {
+		filter.Types = append(filter.Types, strings.ToUpper(artifactType))
+	}
+
+	return filter
+}
+
+func isArtifactCategory(value string) bool {
+	for _, category := range artifactCategories {
+		if category == value {
+			return true
+		}
+	}
+	return false
+}
+
+// categories are the categories to list, artifact types can be in any of them
+func (f artifactFilter) categories() []string {
+
+	if len(f.Types) == 0 {
+		return artifactCategories
+	}
+
+	categories := []string{}
+	for _, artifactType := range f.Types {
+		if !isArtifactCategory(artifactType) {
+			return artifactCategories
+		}
+		categories = append(categories, artifactType)
+	}
+
+	return categories
+}
+
+func artifactFileName(artifact *devicefarm.Artifact) string {
+	// The extension sometimes comes with the dot: "png" and ".png"
+	extension := strings.TrimPrefix(aws.StringValue(artifact.Extension), ".")
+	if extension == "" {
+		return aws.StringValue(artifact.Name)
+	}
+	return aws.StringValue(artifact.Name) + "." + extension
+}
+
+func matchesAnyGlob(patterns []string, artifact *devicefarm.Artifact) bool {
+
+	names := []string{
+		strings.ToLower(artifactFileName(artifact)),
+		strings.ToLower(aws.StringValue(artifact.Name)),
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		for _, name := range names {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (f artifactFilter) matches(category string, artifact *devicefarm.Artifact) bool {
+
+	if len(f.Types) > 0 {
+		found := false
+		for _, artifactType := range f.Types {
+			if artifactType == category || artifactType == aws.StringValue(artifact.Type) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Include) > 0 && !matchesAnyGlob(f.Include, artifact) {
+		return false
+	}
+
+	if len(f.Exclude) > 0 && matchesAnyGlob(f.Exclude, artifact) {
+		return false
+	}
+
+	return true
+}
+
+/* List the artifacts of a run, job, suite or test that match a filter */
+func listArtifactNodes(svc *devicefarm.DeviceFarm, filterArn string, filter artifactFilter) ([]*artifactNode, error) {
+
+	artifacts := []*artifactNode{}
+	for _, category := range filter.categories() {
+		listReq := &devicefarm.ListArtifactsInput{
+			Arn:  aws.String(filterArn),
+			Type: aws.String(category),
+		}
+		err := svc.ListArtifactsPages(listReq, func(page *devicefarm.ListArtifactsOutput, lastPage bool) bool {
+			for _, artifact := range page.Artifacts {
+				if filter.matches(category, artifact) {
+					artifacts = append(artifacts, &artifactNode{Artifact: artifact, Category: category})
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return artifacts, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"strings"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{nil, []string{}},
+		{[]string{"LOG"}, []string{"LOG"}},
+		{[]string{"LOG, VIDEO", "", "SCREENSHOT,,"}, []string{"LOG", "VIDEO", "SCREENSHOT"}},
+	}
+
+	for _, test := range tests {
+		if got := splitList(test.values); strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("splitList(%q) = %q, want %q", test.values, got, test.want)
+		}
+	}
+}
+
+func TestArtifactFilterCategories(t *testing.T) {
+
+	tests := []struct {
+		types []string
+		want  []string
+	}{
+		{nil, artifactCategories},
+		{[]string{"screenshot"}, []string{"SCREENSHOT"}},
+		{[]string{"log,file"}, []string{"LOG", "FILE"}},
+		{[]string{"LOG", "VIDEO"}, artifactCategories},
+	}
+
+	for _, test := range tests {
+		got := newArtifactFilter(test.types, nil, nil).categories()
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("categories of %q = %q, want %q", test.types, got, test.want)
+		}
+	}
+}
+
+func TestArtifactFilterMatches(t *testing.T) {
+
+	video := &devicefarm.Artifact{Name: aws.String("Video"), Type: aws.String("VIDEO"), Extension: aws.String("mp4")}
+	logcat := &devicefarm.Artifact{Name: aws.String("Logcat"), Type: aws.String("DEVICE_LOG"), Extension: aws.String(".txt")}
+	shot := &devicefarm.Artifact{Name: aws.String("0_login"), Type: aws.String("SCREENSHOT"), Extension: aws.String("png")}
+
+	tests := []struct {
+		filter   artifactFilter
+		category string
+		artifact *devicefarm.Artifact
+		want     bool
+	}{
+		{newArtifactFilter(nil, nil, nil), "FILE", video, true},
+		{newArtifactFilter([]string{"video"}, nil, nil), "FILE", video, true},
+		{newArtifactFilter([]string{"file"}, nil, nil), "FILE", video, true},
+		{newArtifactFilter([]string{"LOG,DEVICE_LOG"}, nil, nil), "FILE", video, false},
+		{newArtifactFilter([]string{"DEVICE_LOG"}, nil, nil), "LOG", logcat, true},
+		{newArtifactFilter(nil, []string{"*.txt"}, nil), "LOG", logcat, true},
+		{newArtifactFilter(nil, []string{"LOGCAT"}, nil), "LOG", logcat, true},
+		{newArtifactFilter(nil, []string{"*.mp4"}, nil), "LOG", logcat, false},
+		{newArtifactFilter(nil, nil, []string{"*.png"}), "SCREENSHOT", shot, false},
+		{newArtifactFilter(nil, []string{"0_*"}, []string{"*.txt"}), "SCREENSHOT", shot, true},
+		{newArtifactFilter([]string{"SCREENSHOT"}, []string{"*.png"}, []string{"0_login.png"}), "SCREENSHOT", shot, false},
+	}
+
+	for _, test := range tests {
+		if got := test.filter.matches(test.category, test.artifact); got != test.want {
+			t.Errorf("%+v matches %s %s is %v, want %v", test.filter, test.category, artifactFileName(test.artifact), got, test.want)
+		}
+	}
+}
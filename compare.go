@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"github.com/olekukonko/tablewriter"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of test changes between two runs
+const (
+	changeNewlyFailing = "NEWLY_FAILING"
+	changeNewlyPassing = "NEWLY_PASSING"
+	changeStillFailing = "STILL_FAILING"
+	changeAdded        = "ADDED"
+	changeRemoved      = "REMOVED"
+)
+
+// changeKinds is the order in which changes are shown, regressions first
+var changeKinds = []string{changeNewlyFailing, changeStillFailing, changeNewlyPassing, changeAdded, changeRemoved}
+
+// A comparedRun sums up one side of a comparison
+type comparedRun struct {
+	Name          string               `json:"name"`
+	Arn           string               `json:"arn"`
+	Result        string               `json:"result"`
+	Counters      *devicefarm.Counters `json:"counters"`
+	DeviceMinutes float64              `json:"deviceMinutes"`
+	Seconds       float64              `json:"seconds"`
+}
+
+// A deviceDelta compares the job of a device in both runs, a side is empty when the device only ran in the other
+type deviceDelta struct {
+	Device      string  `json:"device"`
+	Os          string  `json:"os"`
+	BaseResult  string  `json:"baseResult,omitempty"`
+	HeadResult  string  `json:"headResult,omitempty"`
+	BaseMinutes float64 `json:"baseMinutes"`
+	HeadMinutes float64 `json:"headMinutes"`
+	BaseSeconds float64 `json:"baseSeconds"`
+	HeadSeconds float64 `json:"headSeconds"`
+	BaseFailed  int     `json:"baseFailed"`
+	HeadFailed  int     `json:"headFailed"`
+	OnlyIn      string  `json:"onlyIn,omitempty"`
+}
+
+// A testChange is a test whose outcome differs between the runs, or that still fails
+type testChange struct {
+	Change      string  `json:"change"`
+	Device      string  `json:"device"`
+	Suite       string  `json:"suite"`
+	Test        string  `json:"test"`
+	BaseResult  string  `json:"baseResult,omitempty"`
+	HeadResult  string  `json:"headResult,omitempty"`
+	Message     string  `json:"message,omitempty"`
+	BaseSeconds float64 `json:"baseSeconds"`
+	HeadSeconds float64 `json:"headSeconds"`
+}
+
+// A runComparison is what changed from the base run to the head run
+type runComparison struct {
+	Base    comparedRun    `json:"base"`
+	Head    comparedRun    `json:"head"`
+	Devices []*deviceDelta `json:"devices"`
+	Changes []*testChange  `json:"changes"`
+}
+
+// compareOptions are the settings of compare
+type compareOptions struct {
+	Concurrency      int
+	Markdown         string
+	JSON             string
+	FailOnRegression bool
+}
+
+// redirectProgress sends the progress lines to stderr when stdout is taken by the comparison
+func (o compareOptions) redirectProgress() {
+	if o.Markdown == "-" || o.JSON == "-" {
+		progress = os.Stderr
+	}
+}
+
+// isFailing tells if a result counts as a failure when comparing runs
+func isFailing(result string) bool {
+	switch result {
+	case "FAILED", "ERRORED", "STOPPED":
+		return true
+	}
+	return false
+}
+
+/* Load a run to compare, from a file exported with export run or from devicefarm */
+func loadComparedRun(svc *devicefarm.DeviceFarm, projectName string, source string, concurrency int) (*runTree, error) {
+
+	if info, err := os.Stat(source); err == nil && info.Mode().IsRegular() {
+		return loadRunTree(source)
+	}
+
+	runArn, err := lookupRunArn(svc, projectName, source)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "- Fetching run %s\n", runArn)
+	return crawlRunJobs(svc, runArn, concurrency, crawlJobTests)
+}
+
+func newComparedRun(tree *runTree) comparedRun {
+	run := tree.Run
+	return comparedRun{
+		Name:          aws.StringValue(run.Name),
+		Arn:           aws.StringValue(run.Arn),
+		Result:        aws.StringValue(run.Result),
+		Counters:      run.Counters,
+		DeviceMinutes: deviceMinutes(run.DeviceMinutes),
+		Seconds:       testDuration(run.Started, run.Stopped, nil).Seconds(),
+	}
+}
+
+// jobKey aligns the jobs of two runs on the model and os of their device
+func jobKey(job *devicefarm.Job) string {
+	if job.Device == nil {
+		return strings.ToLower(aws.StringValue(job.Name))
+	}
+	return strings.ToLower(aws.StringValue(job.Device.Model) + "|" + aws.StringValue(job.Device.Os))
+}
+
+// keyJobs keys the jobs of a run, a device that ran twice gets a number
+func keyJobs(tree *runTree) ([]string, map[string]*jobNode) {
+
+	keys := []string{}
+	jobs := map[string]*jobNode{}
+	for _, job := range tree.Jobs {
+		key := jobKey(job.Job)
+		for i := 2; jobs[key] != nil; i++ {
+			key = fmt.Sprintf("%s#%d", jobKey(job.Job), i)
+		}
+		keys = append(keys, key)
+		jobs[key] = job
+	}
+
+	return keys, jobs
+}
+
+// keyTests keys the tests of a job on their suite and name, a name seen twice gets a number
+func keyTests(job *jobNode) ([]string, map[string]*testNode, map[string]string) {
+
+	keys := []string{}
+	tests := map[string]*testNode{}
+	suites := map[string]string{}
+	if job == nil {
+		return keys, tests, suites
+	}
+
+	for _, suite := range job.Suites {
+		suiteName := aws.StringValue(suite.Suite.Name)
+		for _, test := range suite.Tests {
+			base := suiteName + "\x00" + aws.StringValue(test.Test.Name)
+			key := base
+			for i := 2; tests[key] != nil; i++ {
+				key = fmt.Sprintf("%s#%d", base, i)
+			}
+			keys = append(keys, key)
+			tests[key] = test
+			suites[key] = suiteName
+		}
+	}
+
+	return keys, tests, suites
+}
+
+func jobDevice(job *devicefarm.Job) (string, string) {
+	if job.Device == nil {
+		return aws.StringValue(job.Name), ""
+	}
+	return aws.StringValue(job.Device.Name), aws.StringValue(job.Device.Os)
+}
+
+func testSeconds(test *testNode) float64 {
+	if test == nil {
+		return 0
+	}
+	return testDuration(test.Test.Started, test.Test.Stopped, test.Test.DeviceMinutes).Seconds()
+}
+
+func failedTests(job *jobNode) int {
+	failed := 0
+	if job == nil {
+		return failed
+	}
+	for _, suite := range job.Suites {
+		for _, test := range suite.Tests {
+			if isFailing(aws.StringValue(test.Test.Result)) {
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+/* Compare two runs device by device and test by test */
+func compareRuns(base *runTree, head *runTree) *runComparison {
+
+	comparison := &runComparison{Base: newComparedRun(base), Head: newComparedRun(head), Devices: []*deviceDelta{}, Changes: []*testChange{}}
+
+	baseKeys, baseJobs := keyJobs(base)
+	headKeys, headJobs := keyJobs(head)
+
+	// Devices of the head run first, in its order, then the ones only the base run had
+	keys := append([]string{}, headKeys...)
+	for _, key := range baseKeys {
+		if headJobs[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		baseJob, headJob := baseJobs[key], headJobs[key]
+
+		delta := &deviceDelta{}
+		if headJob != nil {
+			delta.Device, delta.Os = jobDevice(headJob.Job)
+			delta.HeadResult = aws.StringValue(headJob.Job.Result)
+			delta.HeadMinutes = deviceMinutes(headJob.Job.DeviceMinutes)
+			delta.HeadSeconds = testDuration(headJob.Job.Started, headJob.Job.Stopped, nil).Seconds()
+			delta.HeadFailed = failedTests(headJob)
+		}
+		if baseJob != nil {
+			if headJob == nil {
+				delta.Device, delta.Os = jobDevice(baseJob.Job)
+			}
+			delta.BaseResult = aws.StringValue(baseJob.Job.Result)
+			delta.BaseMinutes = deviceMinutes(baseJob.Job.DeviceMinutes)
+			delta.BaseSeconds = testDuration(baseJob.Job.Started, baseJob.Job.Stopped, nil).Seconds()
+			delta.BaseFailed = failedTests(baseJob)
+		}
+		switch {
+		case baseJob == nil:
+			delta.OnlyIn = "head"
+		case headJob == nil:
+			delta.OnlyIn = "base"
+		}
+		comparison.Devices = append(comparison.Devices, delta)
+
+		// The tests of a device that only ran once are told by the device
+		if baseJob == nil || headJob == nil {
+			continue
+		}
+
+		device := delta.Device + " - " + delta.Os
+		baseTestKeys, baseTests, baseSuites := keyTests(baseJob)
+		headTestKeys, headTests, headSuites := keyTests(headJob)
+
+		testKeys := append([]string{}, headTestKeys...)
+		for _, testKey := range baseTestKeys {
+			if headTests[testKey] == nil {
+				testKeys = append(testKeys, testKey)
+			}
+		}
+
+		for _, testKey := range testKeys {
+			baseTest, headTest := baseTests[testKey], headTests[testKey]
+
+			change := &testChange{Device: device, BaseSeconds: testSeconds(baseTest), HeadSeconds: testSeconds(headTest)}
+			if headTest != nil {
+				change.Suite = headSuites[testKey]
+				change.Test = aws.StringValue(headTest.Test.Name)
+				change.HeadResult = aws.StringValue(headTest.Test.Result)
+				change.Message = aws.StringValue(headTest.Test.Message)
+			}
+			if baseTest != nil {
+				change.Suite = baseSuites[testKey]
+				change.Test = aws.StringValue(baseTest.Test.Name)
+				change.BaseResult = aws.StringValue(baseTest.Test.Result)
+			}
+
+			switch {
+			case baseTest == nil:
+				change.Change = changeAdded
+			case headTest == nil:
+				change.Change = changeRemoved
+				change.Message = ""
+			case isFailing(change.HeadResult) && isFailing(change.BaseResult):
+				change.Change = changeStillFailing
+			case isFailing(change.HeadResult):
+				change.Change = changeNewlyFailing
+			case isFailing(change.BaseResult):
+				change.Change = changeNewlyPassing
+			default:
+				continue
+			}
+			if !isFailing(change.HeadResult) {
+				change.Message = ""
+			}
+
+			comparison.Changes = append(comparison.Changes, change)
+		}
+	}
+
+	rank := map[string]int{}
+	for i, kind := range changeKinds {
+		rank[kind] = i
+	}
+	sort.SliceStable(comparison.Changes, func(i, j int) bool {
+		return rank[comparison.Changes[i].Change] < rank[comparison.Changes[j].Change]
+	})
+
+	return comparison
+}
+
+// count is the number of test changes of a kind
+func (c *runComparison) count(kind string) int {
+	n := 0
+	for _, change := range c.Changes {
+		if change.Change == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// regressions are the tests that fail in the head run and did not fail in the base run,
+// a new test that fails counts as well
+func (c *runComparison) regressions() int {
+	n := 0
+	for _, change := range c.Changes {
+		if change.Change == changeNewlyFailing || (change.Change == changeAdded && isFailing(change.HeadResult)) {
+			n++
+		}
+	}
+	return n
+}
+
+func formatDelta(base float64, head float64, format string) string {
+	return fmt.Sprintf(format+" -> "+format+" (%+"+strings.TrimPrefix(format, "%")+")", base, head, head-base)
+}
+
+func formatSeconds(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+func formatDurationDelta(base float64, head float64) string {
+	delta := formatSeconds(head - base)
+	sign := "+"
+	if delta < 0 {
+		sign = ""
+	}
+	return fmt.Sprintf("%s -> %s (%s%s)", formatSeconds(base), formatSeconds(head), sign, delta)
+}
+
+// deltaCells are the failed tests, minutes and duration of a device, a device
+// that ran in one of the runs only shows its values in that run
+func (d *deviceDelta) deltaCells() []string {
+
+	switch d.OnlyIn {
+	case "head":
+		return []string{fmt.Sprint(d.HeadFailed), fmt.Sprintf("%.2f", d.HeadMinutes), formatSeconds(d.HeadSeconds).String()}
+	case "base":
+		return []string{fmt.Sprint(d.BaseFailed), fmt.Sprintf("%.2f", d.BaseMinutes), formatSeconds(d.BaseSeconds).String()}
+	}
+
+	return []string{
+		formatDelta(float64(d.BaseFailed), float64(d.HeadFailed), "%.0f"),
+		formatDelta(d.BaseMinutes, d.HeadMinutes, "%.2f"),
+		formatDurationDelta(d.BaseSeconds, d.HeadSeconds),
+	}
+}
+
+// changeMessage is the summary of the message of a failing test
+func changeMessage(change *testChange) string {
+	if change.Message == "" {
+		return ""
+	}
+	return problemSummary(change.Message)
+}
+
+func printComparison(c *runComparison) {
+
+	fmt.Printf("%s (%s) -> %s (%s)\n", c.Base.Name, c.Base.Result, c.Head.Name, c.Head.Result)
+	fmt.Printf("- Newly failing: %d, still failing: %d, newly passing: %d, added: %d, removed: %d\n",
+		c.count(changeNewlyFailing), c.count(changeStillFailing), c.count(changeNewlyPassing), c.count(changeAdded), c.count(changeRemoved))
+	fmt.Printf("- Device minutes: %s\n", formatDelta(c.Base.DeviceMinutes, c.Head.DeviceMinutes, "%.2f"))
+	fmt.Printf("- Duration: %s\n", formatDurationDelta(c.Base.Seconds, c.Head.Seconds))
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(60)
+	table.SetHeader([]string{"Device", "Os", "Base", "Head", "Failed tests", "Minutes", "Duration"})
+	for _, d := range c.Devices {
+		table.Append(append([]string{d.Device, d.Os, d.BaseResult, d.HeadResult}, d.deltaCells()...))
+	}
+	table.Render() // Send output
+
+	if len(c.Changes) == 0 {
+		return
+	}
+
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(60)
+	table.SetHeader([]string{"Change", "Device", "Suite", "Test", "Base", "Head", "Message"})
+	for _, change := range c.Changes {
+		table.Append([]string{
+			change.Change,
+			change.Device,
+			change.Suite,
+			change.Test,
+			change.BaseResult,
+			change.HeadResult,
+			changeMessage(change),
+		})
+	}
+	table.Render() // Send output
+}
+
+/* Render a comparison as GitHub flavoured Markdown */
+func markdownComparison(c *runComparison) string {
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "## %s: %s → %s: %s\n\n", markdownCell(c.Base.Name), c.Base.Result, markdownCell(c.Head.Name), c.Head.Result)
+
+	b.WriteString("| Newly failing | Still failing | Newly passing | Added | Removed | Device minutes | Duration |\n")
+	b.WriteString("| ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %s | %s |\n\n",
+		c.count(changeNewlyFailing), c.count(changeStillFailing), c.count(changeNewlyPassing), c.count(changeAdded), c.count(changeRemoved),
+		formatDelta(c.Base.DeviceMinutes, c.Head.DeviceMinutes, "%.2f"), formatDurationDelta(c.Base.Seconds, c.Head.Seconds))
+
+	b.WriteString("### Devices\n\n")
+	b.WriteString("| Device | Os | Base | Head | Failed tests | Minutes | Duration |\n")
+	b.WriteString("| --- | --- | --- | --- | ---: | ---: | ---: |\n")
+	for _, d := range c.Devices {
+		line := append([]string{markdownCell(d.Device), d.Os, d.BaseResult, d.HeadResult}, d.deltaCells()...)
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(line, " | "))
+	}
+	b.WriteString("\n")
+
+	titles := map[string]string{
+		changeNewlyFailing: "Newly failing",
+		changeStillFailing: "Still failing",
+		changeNewlyPassing: "Newly passing",
+		changeAdded:        "Added",
+		changeRemoved:      "Removed",
+	}
+	for _, kind := range changeKinds {
+		if c.count(kind) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "### %s\n\n", titles[kind])
+		b.WriteString("| Device | Suite | Test | Base | Head | Message |\n")
+		b.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+		for _, change := range c.Changes {
+			if change.Change != kind {
+				continue
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n",
+				markdownCell(change.Device), markdownCell(change.Suite), markdownCell(change.Test),
+				change.BaseResult, change.HeadResult, markdownCell(changeMessage(change)))
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// writeOutput writes a document to a file, or to stdout for -
+func writeOutput(fileName string, data []byte) error {
+
+	if fileName == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, data, 0666)
+}
+
+/* Compare a head run with a base run */
+func compare(svc *devicefarm.DeviceFarm, projectName string, baseSource string, headSource string, options compareOptions) error {
+
+	base, err := loadComparedRun(svc, projectName, baseSource, options.Concurrency)
+	if err != nil {
+		return err
+	}
+	head, err := loadComparedRun(svc, projectName, headSource, options.Concurrency)
+	if err != nil {
+		return err
+	}
+
+	comparison := compareRuns(base, head)
+
+	// Only print the tables when stdout is not taken by another format
+	if options.Markdown != "-" && options.JSON != "-" {
+		printComparison(comparison)
+	}
+
+	if options.Markdown != "" {
+		err := writeOutput(options.Markdown, []byte(markdownComparison(comparison)))
+		if err != nil {
+			return err
+		}
+		if options.Markdown != "-" {
+			fmt.Fprintf(progress, "- [MARKDOWN] %s\n", options.Markdown)
+		}
+	}
+
+	if options.JSON != "" {
+		data, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = writeOutput(options.JSON, append(data, '\n'))
+		if err != nil {
+			return err
+		}
+		if options.JSON != "-" {
+			fmt.Fprintf(progress, "- [JSON] %s\n", options.JSON)
+		}
+	}
+
+	if options.FailOnRegression && comparison.regressions() > 0 {
+		return fmt.Errorf("%d tests fail in %s that did not fail in %s", comparison.regressions(), comparison.Head.Name, comparison.Base.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testJob is a job on a device with a suite of tests, given as name and result pairs
+func testJob(model string, os string, results ...string) *jobNode {
+
+	suite := &suiteNode{Suite: &devicefarm.Suite{Name: aws.String("Tests Suite")}}
+	for i := 0; i+1 < len(results); i += 2 {
+		suite.Tests = append(suite.Tests, &testNode{Test: &devicefarm.Test{
+			Name:    aws.String(results[i]),
+			Result:  aws.String(results[i+1]),
+			Message: aws.String(results[i] + " " + results[i+1]),
+		}})
+	}
+
+	return &jobNode{
+		Job: &devicefarm.Job{
+			Name:   aws.String(model),
+			Result: aws.String("PASSED"),
+			Device: &devicefarm.Device{Name: aws.String(model), Model: aws.String(model), Os: aws.String(os)},
+		},
+		Suites: []*suiteNode{suite},
+	}
+}
+
+func testRun(name string, jobs ...*jobNode) *runTree {
+	return &runTree{Run: &devicefarm.Run{Name: aws.String(name), Arn: aws.String("arn:" + name)}, Jobs: jobs}
+}
+
+func TestCompareRuns(t *testing.T) {
+
+	base := testRun("base",
+		testJob("Pixel 4", "11", "login", "PASSED", "logout", "FAILED", "share", "FAILED", "search", "PASSED", "old", "PASSED", "same", "SKIPPED"),
+		testJob("Galaxy S20", "10", "login", "PASSED"),
+		testJob("Nexus 5", "6.0", "login", "ERRORED"),
+	)
+	head := testRun("head",
+		testJob("Pixel 4", "11", "login", "ERRORED", "logout", "PASSED", "share", "STOPPED", "search", "PASSED", "new", "FAILED", "same", "SKIPPED"),
+		testJob("Galaxy S20", "10", "login", "PASSED"),
+		testJob("Galaxy S20", "10", "login", "FAILED"),
+		testJob("iPhone 11", "14.1", "login", "FAILED"),
+	)
+
+	comparison := compareRuns(base, head)
+
+	devices := []struct {
+		device     string
+		onlyIn     string
+		baseFailed int
+		headFailed int
+	}{
+		{"Pixel 4", "", 2, 3},
+		{"Galaxy S20", "", 0, 0},
+		{"Galaxy S20", "head", 0, 1},
+		{"iPhone 11", "head", 0, 1},
+		{"Nexus 5", "base", 1, 0},
+	}
+
+	if len(comparison.Devices) != len(devices) {
+		t.Fatalf("got %d devices, want %d", len(comparison.Devices), len(devices))
+	}
+	for i, want := range devices {
+		got := comparison.Devices[i]
+		if got.Device != want.device || got.OnlyIn != want.onlyIn || got.BaseFailed != want.baseFailed || got.HeadFailed != want.headFailed {
+			t.Errorf("device %d is %s only in %q with %d/%d failed, want %s only in %q with %d/%d failed", i, got.Device, got.OnlyIn, got.BaseFailed, got.HeadFailed, want.device, want.onlyIn, want.baseFailed, want.headFailed)
+		}
+	}
+
+	changes := []struct {
+		change  string
+		test    string
+		message string
+	}{
+		{changeNewlyFailing, "login", "login ERRORED"},
+		{changeStillFailing, "share", "share STOPPED"},
+		{changeNewlyPassing, "logout", ""},
+		{changeAdded, "new", "new FAILED"},
+		{changeRemoved, "old", ""},
+	}
+
+	if len(comparison.Changes) != len(changes) {
+		t.Fatalf("got %d changes, want %d", len(comparison.Changes), len(changes))
+	}
+	for i, want := range changes {
+		got := comparison.Changes[i]
+		if got.Change != want.change || got.Test != want.test || got.Message != want.message || got.Device != "Pixel 4 - 11" || got.Suite != "Tests Suite" {
+			t.Errorf("change %d is %s %s %q on %s, want %s %s %q on Pixel 4 - 11", i, got.Change, got.Test, got.Message, got.Device, want.change, want.test, want.message)
+		}
+	}
+
+	if comparison.Base.Name != "base" || comparison.Head.Name != "head" {
+		t.Errorf("compared %s with %s, want base with head", comparison.Base.Name, comparison.Head.Name)
+	}
+	if n := comparison.count(changeNewlyFailing); n != 1 {
+		t.Errorf("counted %d newly failing tests, want 1", n)
+	}
+}
+
+func TestComparisonRegressions(t *testing.T) {
+
+	tests := []struct {
+		name string
+		base *runTree
+		head *runTree
+		want int
+	}{
+		{"unchanged", testRun("base", testJob("Pixel 4", "11", "login", "PASSED")), testRun("head", testJob("Pixel 4", "11", "login", "PASSED")), 0},
+		{"newly failing", testRun("base", testJob("Pixel 4", "11", "login", "PASSED")), testRun("head", testJob("Pixel 4", "11", "login", "FAILED")), 1},
+		{"still failing", testRun("base", testJob("Pixel 4", "11", "login", "FAILED")), testRun("head", testJob("Pixel 4", "11", "login", "ERRORED")), 0},
+		{"added and failing", testRun("base", testJob("Pixel 4", "11", "login", "PASSED")), testRun("head", testJob("Pixel 4", "11", "login", "PASSED", "signup", "FAILED")), 1},
+		{"added and passing", testRun("base", testJob("Pixel 4", "11", "login", "PASSED")), testRun("head", testJob("Pixel 4", "11", "login", "PASSED", "signup", "PASSED")), 0},
+		{"removed", testRun("base", testJob("Pixel 4", "11", "login", "FAILED")), testRun("head", testJob("Pixel 4", "11")), 0},
+	}
+
+	for _, test := range tests {
+		if got := compareRuns(test.base, test.head).regressions(); got != test.want {
+			t.Errorf("%s: got %d regressions, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompareKeepsStdoutForTheComparison(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	baseFile, headFile := filepath.Join(dir, "base.json"), filepath.Join(dir, "head.json")
+	for fileName, tree := range map[string]*runTree{
+		baseFile: testRun("base", testJob("Pixel 4", "11", "login", "PASSED")),
+		headFile: testRun("head", testJob("Pixel 4", "11", "login", "FAILED")),
+	} {
+		tree.Version = runTreeVersion
+		if err := writeRunTree(tree, fileName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		options  compareOptions
+		stdout   string
+		progress bool
+	}{
+		{"markdown to stdout", compareOptions{Markdown: "-", JSON: filepath.Join(dir, "out.json")}, "## base: ", false},
+		{"json to stdout", compareOptions{Markdown: filepath.Join(dir, "out.md"), JSON: "-"}, "{", false},
+		{"files", compareOptions{Markdown: filepath.Join(dir, "out.md"), JSON: filepath.Join(dir, "out.json")}, "base (", true},
+	}
+
+	for _, test := range tests {
+		stdout, stderr := os.Stdout, os.Stderr
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout, os.Stderr = w, nil
+		progress = w
+		test.options.redirectProgress()
+
+		err = compare(nil, "", baseFile, headFile, test.options)
+		w.Close()
+		os.Stdout, os.Stderr = stdout, stderr
+		progress = ioutil.Discard
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := string(data)
+		if !strings.HasPrefix(out, test.stdout) {
+			t.Errorf("%s: stdout starts with %q, want %q", test.name, firstLine(out), test.stdout)
+		}
+		if got := strings.Contains(out, "- [JSON]") || strings.Contains(out, "- [MARKDOWN]"); got != test.progress {
+			t.Errorf("%s: progress on stdout is %v, want %v:\n%s", test.name, got, test.progress, out)
+		}
+	}
+}
+
+func firstLine(value string) string {
+	return strings.SplitN(value, "\n", 2)[0]
+}
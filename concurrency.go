@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// downloadClient is shared by all downloads so connections are kept alive
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        32,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		r.URL.Opaque = r.URL.Path
+		return nil
+	},
+}
+
+// A rateLimiter spaces out calls so we stay under the devicefarm throttles
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// apiLimiter is applied to every devicefarm API call, it is set from the global flags
+var apiLimiter = &rateLimiter{}
+
+func (l *rateLimiter) SetRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = 0
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+}
+
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	if l.interval <= 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// forEachOrdered runs work for 0..n-1 with at most concurrency running at once,
+// done is called in index order as soon as the work up to that index finished
+func forEachOrdered(n int, concurrency int, work func(i int), done func(i int)) {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	finished := make([]chan struct{}, n)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+
+	go func() {
+		slots := make(chan struct{}, concurrency)
+		for i := 0; i < n; i++ {
+			slots <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-slots
+					close(finished[i])
+				}()
+				work(i)
+			}(i)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		<-finished[i]
+		if done != nil {
+			done(i)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/olekukonko/tablewriter"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// signatureFrames is how many app frames make up a crash signature
+const signatureFrames = 3
+
+// crashHistoryVersion is bumped when the history file changes incompatibly
+const crashHistoryVersion = 1
+
+// Frames of these packages and libraries are not the app's own code
+var frameworkFrames = []string{
+	"java.", "javax.", "sun.", "libcore.", "dalvik.", "kotlin.", "kotlinx.",
+	"android.", "androidx.", "com.android.", "com.google.android.", "org.junit.",
+	"/system/", "/apex/", "/vendor/",
+}
+
+var (
+	javaFrame    = regexp.MustCompile(`^at ([\w$.<>]+)\(`)
+	nativeFrame  = regexp.MustCompile(`^#\d+ pc [0-9a-f]+\s+(\S+)(?: \(([^+)]+))?`)
+	anonymousCls = regexp.MustCompile(`\$\d+`)
+	lambdaCls    = regexp.MustCompile(`\$\$?(?:ExternalSynthetic)?[Ll]ambda\$?[\w$]*`)
+	hexNumbers   = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+	numbers      = regexp.MustCompile(`\d+`)
+)
+
+// A crashGroup is one crash signature with everywhere it was seen
+type crashGroup struct {
+	Id        string      `json:"id"`
+	Signature string      `json:"signature"`
+	Kind      string      `json:"kind"`
+	Exception string      `json:"exception,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Process   string      `json:"process,omitempty"`
+	Frames    []string    `json:"frames,omitempty"`
+	Count     int         `json:"count"`
+	Devices   []string    `json:"devices"`
+	FirstSeen time.Time   `json:"firstSeen"`
+	LastSeen  time.Time   `json:"lastSeen"`
+	Runs      []crashRun  `json:"runs"`
+	Events    []*logEvent `json:"-"`
+}
+
+// A crashRun is how often a signature was seen in one run
+type crashRun struct {
+	Arn     string    `json:"arn"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Count   int       `json:"count"`
+	Devices []string  `json:"devices"`
+}
+
+// A crashHistory keeps the crash groups of earlier runs
+type crashHistory struct {
+	Version int                    `json:"version"`
+	Groups  map[string]*crashGroup `json:"groups"`
+}
+
+func isFrameworkFrame(frame string) bool {
+	for _, prefix := range frameworkFrames {
+		if strings.HasPrefix(frame, prefix) || strings.Contains(frame, " "+prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFrame keeps the method of a stack frame, without line numbers,
+// addresses or the numbers the compiler gives to anonymous classes and lambdas
+func normalizeFrame(line string) string {
+
+	line = strings.TrimSpace(line)
+
+	if m := javaFrame.FindStringSubmatch(line); m != nil {
+		frame := lambdaCls.ReplaceAllString(m[1], "$$Lambda")
+		return anonymousCls.ReplaceAllString(frame, "$$")
+	}
+
+	if m := nativeFrame.FindStringSubmatch(line); m != nil {
+		// App libraries are installed in a random directory on every device
+		library := m[1]
+		if !isFrameworkFrame(library) {
+			library = path.Base(library)
+		}
+		if m[2] != "" {
+			return library + " (" + m[2] + ")"
+		}
+		return library
+	}
+
+	return ""
+}
+
+// normalizeMessage removes the addresses and numbers that differ between occurrences
+func normalizeMessage(message string) string {
+	message = hexNumbers.ReplaceAllString(message, "0x?")
+	return numbers.ReplaceAllString(message, "?")
+}
+
+// signatureFramesOf picks the top app frames of a stack, or the top frames
+// when none of them is the app's own code
+func signatureFramesOf(event *logEvent) []string {
+
+	all := []string{}
+	app := []string{}
+	for _, line := range event.Stack {
+		// Only the frames of the exception itself, not of its causes
+		if strings.HasPrefix(line, "Caused by:") {
+			break
+		}
+
+		frame := normalizeFrame(line)
+		if frame == "" {
+			continue
+		}
+		all = append(all, frame)
+
+		isApp := !isFrameworkFrame(frame)
+		if event.Process != "" && strings.Contains(event.Process, ".") {
+			isApp = isApp || strings.HasPrefix(frame, event.Process)
+		}
+		if isApp {
+			app = append(app, frame)
+		}
+	}
+
+	if len(app) == 0 {
+		app = all
+	}
+	if len(app) > signatureFrames {
+		app = app[:signatureFrames]
+	}
+
+	return app
+}
+
+/* Compute the signature of a crash: the exception type and the top app frames */
+func crashSignature(event *logEvent) (string, []string) {
+
+	frames := signatureFramesOf(event)
+
+	parts := []string{event.Kind, event.Exception}
+	if len(frames) > 0 {
+		parts = append(parts, frames...)
+	} else {
+		// Without a stack the message is all we have to tell crashes apart
+		parts = append(parts, event.Process, normalizeMessage(event.Message))
+	}
+
+	return strings.Join(parts, "|"), frames
+}
+
+func signatureId(signature string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(signature)))[:12]
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" || containsString(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// runTime is when the run was created, the logs only have the time of day
+func runTime(tree *runTree) time.Time {
+	if tree.Run.Created != nil {
+		return *tree.Run.Created
+	}
+	return tree.Exported
+}
+
+/* Group the crashes of all devices of a run by signature, most widespread first */
+func groupCrashes(tree *runTree) []*crashGroup {
+
+	groups := []*crashGroup{}
+	bySignature := map[string]*crashGroup{}
+	seen := runTime(tree)
+
+	for _, job := range tree.Jobs {
+		for _, event := range job.Events {
+			if !event.isCrash() {
+				continue
+			}
+
+			signature, frames := crashSignature(event)
+			event.Signature = signatureId(signature)
+
+			group, found := bySignature[signature]
+			if !found {
+				group = &crashGroup{
+					Id:        event.Signature,
+					Signature: signature,
+					Kind:      event.Kind,
+					Exception: event.Exception,
+					Message:   event.Message,
+					Process:   event.Process,
+					Frames:    frames,
+					Devices:   []string{},
+					FirstSeen: seen,
+					LastSeen:  seen,
+				}
+				bySignature[signature] = group
+				groups = append(groups, group)
+			}
+
+			group.Count++
+			group.Devices = appendUnique(group.Devices, jobFriendlyName(job.Job))
+			group.Events = append(group.Events, event)
+		}
+	}
+
+	for _, group := range groups {
+		group.Runs = []crashRun{{
+			Arn:     aws.StringValue(tree.Run.Arn),
+			Name:    aws.StringValue(tree.Run.Name),
+			Created: seen,
+			Count:   group.Count,
+			Devices: group.Devices,
+		}}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].Devices) != len(groups[j].Devices) {
+			return len(groups[i].Devices) > len(groups[j].Devices)
+		}
+		return groups[i].Count > groups[j].Count
+	})
+
+	return groups
+}
+
+func loadCrashHistory(fileName string) (*crashHistory, error) {
+
+	history := &crashHistory{Version: crashHistoryVersion, Groups: map[string]*crashGroup{}}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, history)
+	if err != nil {
+		return nil, err
+	}
+
+	if history.Version > crashHistoryVersion {
+		return nil, fmt.Errorf("%s has version %d, this version of devicefarm-cli reads version %d", fileName, history.Version, crashHistoryVersion)
+	}
+	if history.Groups == nil {
+		history.Groups = map[string]*crashGroup{}
+	}
+
+	return history, nil
+}
+
+// merge adds the crashes of a run to the history, a run that was merged before is replaced
+func (h *crashHistory) merge(groups []*crashGroup) {
+
+	for _, group := range groups {
+		old, found := h.Groups[group.Id]
+		if !found {
+			stored := *group
+			stored.Runs = append([]crashRun{}, group.Runs...)
+			h.Groups[group.Id] = &stored
+			continue
+		}
+
+		for _, run := range group.Runs {
+			runs := []crashRun{}
+			for _, r := range old.Runs {
+				if r.Arn != run.Arn {
+					runs = append(runs, r)
+				}
+			}
+			old.Runs = append(runs, run)
+		}
+
+		// Totals and dates are derived from the runs, so merging again changes nothing
+		old.Count = 0
+		old.Devices = []string{}
+		old.FirstSeen = time.Time{}
+		old.LastSeen = time.Time{}
+		for _, r := range old.Runs {
+			old.Count += r.Count
+			for _, device := range r.Devices {
+				old.Devices = appendUnique(old.Devices, device)
+			}
+			if old.FirstSeen.IsZero() || r.Created.Before(old.FirstSeen) {
+				old.FirstSeen = r.Created
+			}
+			if r.Created.After(old.LastSeen) {
+				old.LastSeen = r.Created
+			}
+		}
+	}
+}
+
+// annotate copies the first seen date and the earlier runs from the history to the groups of a run
+func (h *crashHistory) annotate(groups []*crashGroup) {
+	for _, group := range groups {
+		if stored, found := h.Groups[group.Id]; found {
+			group.FirstSeen = stored.FirstSeen
+			group.Runs = stored.Runs
+		}
+	}
+}
+
+func writeCrashHistory(history *crashHistory, fileName string) error {
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	// Write next to the history and rename, so an interrupted report keeps the old one
+	tmpName := fileName + ".tmp"
+	err = ioutil.WriteFile(tmpName, data, 0666)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, fileName)
+}
+
+/* Group the crashes of a run and add them to the crash history file */
+func updateCrashHistory(groups []*crashGroup, fileName string) error {
+
+	history, err := loadCrashHistory(fileName)
+	if err != nil {
+		return err
+	}
+
+	history.merge(groups)
+	history.annotate(groups)
+
+	return writeCrashHistory(history, fileName)
+}
+
+func (g *crashGroup) title() string {
+	title := g.Kind
+	if g.Exception != "" {
+		title += " " + g.Exception
+	}
+	if g.Process != "" {
+		title += " in " + g.Process
+	}
+	return title
+}
+
+func printCrashGroups(tree *runTree, groups []*crashGroup) {
+
+	crashes := 0
+	for _, job := range tree.Jobs {
+		crashes += crashCount(job)
+	}
+	if crashes == 0 {
+		return
+	}
+
+	fmt.Fprintf(progress, "- Found %d crashes with %d signatures\n", crashes, len(groups))
+	for _, group := range groups {
+		seen := "new"
+		if len(group.Runs) > 1 {
+			seen = fmt.Sprintf("seen in %d runs since %s", len(group.Runs), group.FirstSeen.Format("2006-01-02"))
+		}
+		fmt.Fprintf(progress, "- [%s] %s: %s, %d times on %d devices (%s)\n", group.Kind, group.Id, group.title(), group.Count, len(group.Devices), seen)
+	}
+}
+
+/* List the crash signatures of a history file */
+func listCrashHistory(fileName string, since time.Duration) error {
+
+	history, err := loadCrashHistory(fileName)
+	if err != nil {
+		return err
+	}
+
+	groups := []*crashGroup{}
+	for _, group := range history.Groups {
+		if since > 0 && time.Since(group.LastSeen) > since {
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if !groups[i].LastSeen.Equal(groups[j].LastSeen) {
+			return groups[i].LastSeen.After(groups[j].LastSeen)
+		}
+		return groups[i].Count > groups[j].Count
+	})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(60)
+	table.SetHeader([]string{"Id", "Crash", "Count", "Runs", "Devices", "First seen", "Last seen"})
+	for _, group := range groups {
+		table.Append([]string{
+			group.Id,
+			group.title(),
+			fmt.Sprint(group.Count),
+			fmt.Sprint(len(group.Runs)),
+			fmt.Sprint(len(group.Devices)),
+			group.FirstSeen.Format("2006-01-02 15:04"),
+			group.LastSeen.Format("2006-01-02 15:04"),
+		})
+	}
+	table.Render() // Send output
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeFrame(t *testing.T) {
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"at com.example.app.Home.onClick(Home.java:42)", "com.example.app.Home.onClick"},
+		{"  at com.example.app.Home$1.run(Home.java:50)", "com.example.app.Home$.run"},
+		{"at com.example.app.Home$$ExternalSyntheticLambda0.onClick(Unknown Source:2)", "com.example.app.Home$Lambda.onClick"},
+		{"at com.example.app.Home$$Lambda$12.run(Unknown Source)", "com.example.app.Home$Lambda.run"},
+		{"#00 pc 0000000000012345  /data/app/~~a1b2==/com.example.app/lib/arm64/libnative.so (crash+12)", "libnative.so (crash)"},
+		{"#01 pc 00000000000a1b2c  /system/lib64/libc.so (abort+164)", "/system/lib64/libc.so (abort)"},
+		{"#02 pc 0000000000001234  /data/app/lib/libplain.so", "libplain.so"},
+		{"Caused by: java.io.IOException", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := normalizeFrame(test.line); got != test.want {
+			t.Errorf("normalizeFrame(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func crashEvent(exception string, message string, stack ...string) *logEvent {
+	return &logEvent{Kind: eventFatalException, Process: "com.example.app", Exception: exception, Message: message, Stack: stack}
+}
+
+func TestCrashSignature(t *testing.T) {
+
+	base := crashEvent("java.lang.IllegalStateException", "Fragment not attached",
+		"at android.view.View.performClick(View.java:7448)",
+		"at com.example.app.Home.onClick(Home.java:42)",
+		"at com.example.app.Home$1.run(Home.java:50)",
+		"at com.example.app.Main.start(Main.java:10)",
+		"at com.example.app.Main.init(Main.java:5)",
+	)
+
+	tests := []struct {
+		name   string
+		event  *logEvent
+		same   bool
+		frames []string
+	}{
+		{"other lines and message", crashEvent("java.lang.IllegalStateException", "Fragment Home not attached",
+			"at android.view.View.performClick(View.java:7500)",
+			"at com.example.app.Home.onClick(Home.java:43)",
+			"at com.example.app.Home$2.run(Home.java:51)",
+			"at com.example.app.Main.start(Main.java:11)",
+		), true, []string{"com.example.app.Home.onClick", "com.example.app.Home$.run", "com.example.app.Main.start"}},
+		{"other exception", crashEvent("java.lang.NullPointerException", "Fragment not attached", base.Stack...), false, nil},
+		{"other frames", crashEvent("java.lang.IllegalStateException", "Fragment not attached",
+			"at com.example.app.Settings.onClick(Settings.java:42)",
+		), false, []string{"com.example.app.Settings.onClick"}},
+		{"causes are left out", crashEvent("java.lang.IllegalStateException", "",
+			"at com.example.app.Home.onClick(Home.java:42)",
+			"Caused by: java.io.IOException",
+			"at com.example.app.Disk.read(Disk.java:1)",
+		), false, []string{"com.example.app.Home.onClick"}},
+		{"framework only", crashEvent("java.lang.IllegalStateException", "",
+			"at android.view.View.performClick(View.java:7448)",
+			"at android.os.Handler.dispatchMessage(Handler.java:106)",
+		), false, []string{"android.view.View.performClick", "android.os.Handler.dispatchMessage"}},
+	}
+
+	want, _ := crashSignature(base)
+	for _, test := range tests {
+		got, frames := crashSignature(test.event)
+		if (got == want) != test.same {
+			t.Errorf("%s: signature %q, same as %q should be %v", test.name, got, want, test.same)
+		}
+		if test.frames != nil && strings.Join(frames, "|") != strings.Join(test.frames, "|") {
+			t.Errorf("%s: frames %q, want %q", test.name, frames, test.frames)
+		}
+	}
+
+	// Without a stack the message tells crashes apart, without its numbers
+	a, _ := crashSignature(&logEvent{Kind: eventANR, Process: "com.example.app", Message: "Input dispatching timed out (waited 5001ms, 0x7f12)"})
+	b, _ := crashSignature(&logEvent{Kind: eventANR, Process: "com.example.app", Message: "Input dispatching timed out (waited 5003ms, 0x7f99)"})
+	if a != b {
+		t.Errorf("ANR signatures %q and %q differ", a, b)
+	}
+}
+
+// crashRunTree is a run created at the given time with the events on each device
+func crashRunTree(name string, created time.Time, events map[string][]*logEvent) *runTree {
+
+	tree := &runTree{Run: &devicefarm.Run{Arn: aws.String("arn:" + name), Name: aws.String(name), Created: aws.Time(created)}}
+	for _, device := range []string{"Pixel 4", "Galaxy S20", "Nexus 5"} {
+		if events[device] == nil {
+			continue
+		}
+		tree.Jobs = append(tree.Jobs, &jobNode{Job: &devicefarm.Job{Name: aws.String(device)}, Events: events[device]})
+	}
+	return tree
+}
+
+func TestGroupCrashes(t *testing.T) {
+
+	npe := func() *logEvent {
+		return crashEvent("java.lang.NullPointerException", "", "at com.example.app.Home.onClick(Home.java:42)")
+	}
+	ise := crashEvent("java.lang.IllegalStateException", "", "at com.example.app.Home.onResume(Home.java:12)")
+	tag := &logEvent{Kind: eventTag, Tag: "Checkout", Message: "paid"}
+
+	created := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	tree := crashRunTree("nightly", created, map[string][]*logEvent{
+		"Pixel 4":    {ise, npe(), npe(), tag},
+		"Galaxy S20": {npe()},
+		"Nexus 5":    {tag},
+	})
+
+	groups := groupCrashes(tree)
+
+	tests := []struct {
+		exception string
+		count     int
+		devices   []string
+	}{
+		{"java.lang.NullPointerException", 3, []string{"Pixel 4", "Galaxy S20"}},
+		{"java.lang.IllegalStateException", 1, []string{"Pixel 4"}},
+	}
+
+	if len(groups) != len(tests) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(tests))
+	}
+	for i, test := range tests {
+		g := groups[i]
+		if g.Exception != test.exception || g.Count != test.count || strings.Join(g.Devices, "|") != strings.Join(test.devices, "|") {
+			t.Errorf("group %d is %s %d times on %v, want %s %d times on %v", i, g.Exception, g.Count, g.Devices, test.exception, test.count, test.devices)
+		}
+		if !g.FirstSeen.Equal(created) || len(g.Runs) != 1 || g.Runs[0].Arn != "arn:nightly" {
+			t.Errorf("group %d was first seen %s in %+v, want once in the run", i, g.FirstSeen, g.Runs)
+		}
+		for _, event := range g.Events {
+			if event.Signature != g.Id {
+				t.Errorf("event of group %d has signature %s, want %s", i, event.Signature, g.Id)
+			}
+		}
+	}
+	if tag.Signature != "" {
+		t.Errorf("tagged line got signature %s", tag.Signature)
+	}
+}
+
+func TestCrashHistoryMerge(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "history", "crashes.json")
+
+	npe := func() *logEvent {
+		return crashEvent("java.lang.NullPointerException", "", "at com.example.app.Home.onClick(Home.java:42)")
+	}
+	monday := time.Date(2020, 10, 5, 12, 0, 0, 0, time.UTC)
+	tuesday := monday.Add(24 * time.Hour)
+
+	runs := []struct {
+		tree      *runTree
+		count     int
+		devices   []string
+		runs      int
+		firstSeen time.Time
+		lastSeen  time.Time
+	}{
+		{crashRunTree("monday", monday, map[string][]*logEvent{"Pixel 4": {npe()}}), 1, []string{"Pixel 4"}, 1, monday, monday},
+		{crashRunTree("tuesday", tuesday, map[string][]*logEvent{"Galaxy S20": {npe(), npe()}}), 3, []string{"Pixel 4", "Galaxy S20"}, 2, monday, tuesday},
+		// Reporting a run again replaces it instead of counting it twice
+		{crashRunTree("tuesday", tuesday, map[string][]*logEvent{"Galaxy S20": {npe(), npe()}}), 3, []string{"Pixel 4", "Galaxy S20"}, 2, monday, tuesday},
+		{crashRunTree("monday", monday, map[string][]*logEvent{"Nexus 5": {npe()}}), 3, []string{"Galaxy S20", "Nexus 5"}, 2, monday, tuesday},
+	}
+
+	for i, run := range runs {
+		groups := groupCrashes(run.tree)
+		err := updateCrashHistory(groups, fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The groups of the run know about the earlier runs
+		if len(groups) != 1 || !groups[0].FirstSeen.Equal(run.firstSeen) || len(groups[0].Runs) != run.runs {
+			t.Errorf("run %d: annotated groups %+v, want first seen %s in %d runs", i, groups, run.firstSeen, run.runs)
+		}
+
+		history, err := loadCrashHistory(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history.Groups) != 1 {
+			t.Fatalf("run %d: history has %d groups, want 1", i, len(history.Groups))
+		}
+		for _, g := range history.Groups {
+			if g.Count != run.count || strings.Join(g.Devices, "|") != strings.Join(run.devices, "|") || len(g.Runs) != run.runs ||
+				!g.FirstSeen.Equal(run.firstSeen) || !g.LastSeen.Equal(run.lastSeen) {
+				t.Errorf("run %d: history has %d crashes on %v in %d runs from %s to %s, want %d on %v in %d runs from %s to %s",
+					i, g.Count, g.Devices, len(g.Runs), g.FirstSeen, g.LastSeen, run.count, run.devices, run.runs, run.firstSeen, run.lastSeen)
+			}
+		}
+	}
+
+	if _, err := os.Stat(fileName + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("left the temporary history file behind")
+	}
+}
+
+func TestLoadCrashHistory(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		groups  int
+		err     bool
+	}{
+		{"missing.json", "", 0, false},
+		{"empty.json", `{"version": 1}`, 0, false},
+		{"groups.json", `{"version": 1, "groups": {"abc": {"id": "abc", "count": 2}}}`, 1, false},
+		{"newer.json", `{"version": 99, "groups": {}}`, 0, true},
+		{"broken.json", `{"version":`, 0, true},
+	}
+
+	for _, test := range tests {
+		fileName := filepath.Join(dir, test.name)
+		if test.content != "" {
+			writeTestFile(t, fileName, test.content)
+		}
+		history, err := loadCrashHistory(fileName)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want an error %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && len(history.Groups) != test.groups {
+			t.Errorf("%s: got %d groups, want %d", test.name, len(history.Groups), test.groups)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"github.com/olekukonko/tablewriter"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A deviceCatalog is a snapshot of the device list kept on disk
+type deviceCatalog struct {
+	Fetched time.Time
+	Devices []*devicefarm.Device
+}
+
+// deviceCacheOptions controls the device catalog on disk, it is set from the global flags
+var deviceCacheOptions = struct {
+	Path    string
+	TTL     time.Duration
+	Refresh bool
+}{
+	TTL: 24 * time.Hour,
+}
+
+func deviceCachePath() (string, error) {
+
+	if deviceCacheOptions.Path != "" {
+		return deviceCacheOptions.Path, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "devicefarm-cli", "devices.json"), nil
+}
+
+// deviceSnapshotPath is the catalog devices diff compares with, kept next to the
+// cache but only written by devices diff so a cache refresh never moves it
+func deviceSnapshotPath() (string, error) {
+
+	cachePath, err := deviceCachePath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(cachePath), "devices-snapshot.json"), nil
+}
+
+func loadDeviceCatalog(cachePath string) (*deviceCatalog, error) {
+
+	data, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &deviceCatalog{}
+	err = json.Unmarshal(data, catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+func saveDeviceCatalog(cachePath string, catalog *deviceCatalog) error {
+
+	err := os.MkdirAll(filepath.Dir(cachePath), 0777)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write next to the catalog and rename, so a reader never sees half a file
+	tmpPath := cachePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0666)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, cachePath)
+}
+
+/* List all devices, from the catalog on disk when it is recent enough */
+func cachedDevices(svc *devicefarm.DeviceFarm) ([]*devicefarm.Device, error) {
+
+	cachePath, err := deviceCachePath()
+	if err != nil {
+		return listAllDevices(svc)
+	}
+
+	if !deviceCacheOptions.Refresh {
+		catalog, err := loadDeviceCatalog(cachePath)
+		if err == nil && time.Since(catalog.Fetched) < deviceCacheOptions.TTL {
+			return catalog.Devices, nil
+		}
+	}
+
+	devices, err := listAllDevices(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveDeviceCatalog(cachePath, &deviceCatalog{Fetched: time.Now(), Devices: devices})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "- Warning: could not save the device catalog %s: %s\n", cachePath, err)
+	}
+
+	return devices, nil
+}
+
+/* Report the devices added, removed or changed since the last snapshot */
+func diffDevices(svc *devicefarm.DeviceFarm) error {
+
+	snapshotPath, err := deviceSnapshotPath()
+	if err != nil {
+		return err
+	}
+
+	previous, err := loadDeviceCatalog(snapshotPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	devices, err := listAllDevices(svc)
+	if err != nil {
+		return err
+	}
+
+	current := &deviceCatalog{Fetched: time.Now(), Devices: devices}
+
+	if previous == nil {
+		fmt.Printf("- No previous snapshot, saving %d devices to %s\n", len(devices), snapshotPath)
+		return saveDeviceCatalog(snapshotPath, current)
+	}
+
+	fmt.Printf("- Changes since %s\n", previous.Fetched.Format(time.RFC3339))
+
+	before := map[string]*devicefarm.Device{}
+	for _, m := range previous.Devices {
+		before[aws.StringValue(m.Arn)] = m
+	}
+
+	after := map[string]*devicefarm.Device{}
+	for _, m := range current.Devices {
+		after[aws.StringValue(m.Arn)] = m
+	}
+
+	lines := [][]string{}
+	for arn, m := range after {
+		old, found := before[arn]
+		if !found {
+			lines = append(lines, []string{"ADDED", aws.StringValue(m.Name), aws.StringValue(m.Os), aws.StringValue(m.Availability), arn})
+			continue
+		}
+
+		if aws.StringValue(old.Availability) != aws.StringValue(m.Availability) {
+			change := fmt.Sprintf("%s -> %s", aws.StringValue(old.Availability), aws.StringValue(m.Availability))
+			lines = append(lines, []string{"CHANGED", aws.StringValue(m.Name), aws.StringValue(m.Os), change, arn})
+		}
+	}
+
+	for arn, m := range before {
+		if _, found := after[arn]; !found {
+			lines = append(lines, []string{"REMOVED", aws.StringValue(m.Name), aws.StringValue(m.Os), aws.StringValue(m.Availability), arn})
+		}
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i][0] != lines[j][0] {
+			return lines[i][0] < lines[j][0]
+		}
+		if lines[i][1] != lines[j][1] {
+			return lines[i][1] < lines[j][1]
+		}
+		return compareVersions(lines[i][2], lines[j][2]) < 0
+	})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Change", "Name", "Os", "Availability", "Arn"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(50)
+	table.AppendBulk(lines)
+	table.Render() // Send output
+
+	return saveDeviceCatalog(snapshotPath, current)
+}
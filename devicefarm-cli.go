@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/devicefarm"
 	"github.com/olekukonko/tablewriter"
@@ -26,6 +27,11 @@ func main() {
 	svc := devicefarm.New(session.Must(session.NewSession()),
 		&aws.Config{Region: aws.String("us-west-2")})
 
+	// Space out all API calls, concurrent crawling would hit the throttles otherwise
+	svc.Handlers.Send.PushFront(func(r *request.Request) {
+		apiLimiter.Wait()
+	})
+
 	app := cli.NewApp()
 	app.Name = "devicefarm-cli"
 	app.Usage = "allows you to interact with AWS devicefarm from the command line"
@@ -36,6 +42,39 @@ func main() {
 		Email: "Patrick.Debois@jedi.be",
 	}}
 
+	app.Flags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "refresh",
+			EnvVars: []string{"DF_REFRESH"},
+			Usage:   "refresh the device catalog cached on disk",
+		},
+		&cli.DurationFlag{
+			Name:    "device-cache-ttl",
+			EnvVars: []string{"DF_DEVICE_CACHE_TTL"},
+			Usage:   "how long the device catalog cached on disk is used",
+			Value:   deviceCacheOptions.TTL,
+		},
+		&cli.Float64Flag{
+			Name:    "api-rate",
+			EnvVars: []string{"DF_API_RATE"},
+			Usage:   "maximum devicefarm API calls per second, 0 for no limit",
+			Value:   5,
+		},
+		&cli.StringFlag{
+			Name:    "device-cache",
+			EnvVars: []string{"DF_DEVICE_CACHE"},
+			Usage:   "path of the device catalog cache (defaults to the user cache directory)",
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		deviceCacheOptions.Refresh = c.Bool("refresh")
+		deviceCacheOptions.TTL = c.Duration("device-cache-ttl")
+		deviceCacheOptions.Path = c.String("device-cache")
+		apiLimiter.SetRate(c.Float64("api-rate"))
+		return nil
+	}
+
 	app.Commands = []*cli.Command{
 		{
 			Name:  "create",
@@ -53,7 +92,12 @@ func main() {
 						&cli.StringFlag{
 							Name:    "device",
 							EnvVars: []string{"DF_DEVICE"},
-							Usage:   "device name",
+							Usage:   "device Arn, name or model, optionally with os constraints (\"Galaxy S21@>=12\")",
+						},
+						&cli.StringFlag{
+							Name:    "platform",
+							EnvVars: []string{"DF_PLATFORM"},
+							Usage:   "platform of the device [ANDROID,IOS]",
 						},
 						&cli.StringFlag{
 							Name:  "name",
@@ -63,13 +107,65 @@ func main() {
 					Action: func(c *cli.Context) error {
 						projectArn := c.String("project")
 						deviceName := c.String("device")
+						platform := c.String("platform")
 						poolName := c.String("name")
-						_, err := createPoolFromDevice(svc, poolName, deviceName, projectArn)
+						_, err := createPoolFromDevice(svc, poolName, deviceName, platform, projectArn)
 						return err
 					},
 				},
 			},
 		},
+		{
+			Name:  "devicepool",
+			Usage: "work with devicepools",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "check",
+					Usage: "check which devices of a devicepool are compatible with an app and test type",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "device-pool",
+							EnvVars: []string{"DF_DEVICE_POOL"},
+							Usage:   "devicepool Arn",
+						},
+						&cli.StringFlag{
+							Name:    "app",
+							EnvVars: []string{"DF_APP"},
+							Usage:   "Arn of the app upload",
+						},
+						&cli.StringFlag{
+							Name:    "test-type",
+							EnvVars: []string{"DF_TEST_TYPE"},
+							Usage:   "type of test [UIAUTOMATOR, CALABASH, APPIUM_JAVA_TESTNG, ...]",
+						},
+						&cli.BoolFlag{
+							Name:  "warn-incompatible",
+							Usage: "only warn instead of failing when no device is compatible",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						devicePoolArn := c.String("device-pool")
+						appArn := c.String("app")
+						testType := c.String("test-type")
+						warnIncompatible := c.Bool("warn-incompatible")
+						return checkDevicePool(svc, devicePoolArn, appArn, testType, warnIncompatible)
+					},
+				},
+			},
+		},
+		{
+			Name:  "devices",
+			Usage: "work with the device catalog",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "diff",
+					Usage: "report devices added, removed or changed in availability since the last snapshot",
+					Action: func(c *cli.Context) error {
+						return diffDevices(svc)
+					},
+				},
+			},
+		},
 		{
 			Name:  "list",
 			Usage: "list various elements on devicefarm",
@@ -85,17 +181,115 @@ func main() {
 				{
 					Name:  "devices",
 					Usage: "list the devices", // globally
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "name",
+							Usage: "device name, model or manufacturer to match",
+						},
+						&cli.StringFlag{
+							Name:    "platform",
+							EnvVars: []string{"DF_PLATFORM"},
+							Usage:   "platform of the device [ANDROID,IOS]",
+						},
+						&cli.StringFlag{
+							Name:  "form-factor",
+							Usage: "form factor of the device [PHONE,TABLET]",
+						},
+						&cli.StringFlag{
+							Name:  "os",
+							Usage: "comma separated os constraints (\">=10,<13\")",
+						},
+						&cli.StringFlag{
+							Name:  "manufacturer",
+							Usage: "manufacturer of the device",
+						},
+						&cli.StringSliceFlag{
+							Name:  "availability",
+							Usage: "availability of the device [AVAILABLE,HIGHLY_AVAILABLE,BUSY,TEMPORARY_NOT_AVAILABLE]",
+						},
+						&cli.StringFlag{
+							Name:  "fleet-type",
+							Usage: "fleet type of the device [PUBLIC,PRIVATE]",
+						},
+						&cli.BoolFlag{
+							Name:  "remote-access",
+							Usage: "only devices with remote access enabled (or disabled with --remote-access=false)",
+						},
+						&cli.BoolFlag{
+							Name:  "remote-debug",
+							Usage: "only devices with remote debugging enabled (or disabled with --remote-debug=false)",
+						},
+						&cli.StringFlag{
+							Name:  "sort",
+							Usage: "comma separated columns to sort on, prefix with - to sort descending (\"platform,-os\")",
+							Value: "name,os",
+						},
+						&cli.StringFlag{
+							Name:  "columns",
+							Usage: "comma separated columns to show [" + strings.Join(deviceColumnNames(), ",") + "]",
+							Value: strings.Join(defaultDeviceColumns, ","),
+						},
+					},
 					Action: func(c *cli.Context) error {
-						listDevices(svc)
-						return nil
+						osConstraints, err := parseVersionConstraints(c.String("os"))
+						if err != nil {
+							return err
+						}
+
+						filter := deviceFilter{
+							Name:         c.String("name"),
+							Platform:     c.String("platform"),
+							FormFactor:   c.String("form-factor"),
+							Os:           osConstraints,
+							Manufacturer: c.String("manufacturer"),
+							Availability: splitList(c.StringSlice("availability")),
+							FleetType:    c.String("fleet-type"),
+						}
+						if c.IsSet("remote-access") {
+							filter.RemoteAccess = aws.Bool(c.Bool("remote-access"))
+						}
+						if c.IsSet("remote-debug") {
+							filter.RemoteDebug = aws.Bool(c.Bool("remote-debug"))
+						}
+
+						sortBy := strings.Split(c.String("sort"), ",")
+						columns := strings.Split(c.String("columns"), ",")
+						return listDevices(svc, filter, sortBy, columns)
 					},
 				},
 				{
 					Name:  "samples",
-					Usage: "list the samples",
+					Usage: "list the performance samples of a job",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "project",
+							EnvVars: []string{"DF_PROJECT"},
+							Usage:   "project Arn or project name",
+						},
+						&cli.StringFlag{
+							Name:    "run",
+							EnvVars: []string{"DF_RUN"},
+							Usage:   "run Arn or run name",
+						},
+						&cli.StringFlag{
+							Name:    "job",
+							EnvVars: []string{"DF_JOB"},
+							Usage:   "job Arn, job name or device name",
+						},
+					},
 					Action: func(c *cli.Context) error {
-						// Not yet implemented
-						// listSamples()
+						runArn, err := lookupRunArn(svc, c.String("project"), c.String("run"))
+						if err != nil {
+							return err
+						}
+						jobArn, err := lookupJobArn(svc, runArn, c.String("job"))
+						if err != nil {
+							return err
+						}
+						if jobArn == "" {
+							return errors.New("we need a job, use --job")
+						}
+						listSamples(svc, jobArn)
 						return nil
 					},
 				},
@@ -146,10 +340,18 @@ func main() {
 							EnvVars: []string{"DF_JOB"},
 							Usage:   "job Arn or run description",
 						},
-						&cli.StringFlag{
+						&cli.StringSliceFlag{
 							Name:    "type",
 							EnvVars: []string{"DF_ARTIFACT_TYPE"},
-							Usage:   "type of the artifact [LOG,FILE,SCREENSHOT]",
+							Usage:   "categories [LOG,FILE,SCREENSHOT] or types [VIDEO,DEVICE_LOG,CUSTOMER_ARTIFACT,TESTSPEC_OUTPUT,...] of the artifacts",
+						},
+						&cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "only artifacts with a name or name.extension matching the glob (\"*.mp4\")",
+						},
+						&cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "skip artifacts with a name or name.extension matching the glob",
 						},
 					},
 					Action: func(c *cli.Context) error {
@@ -163,11 +365,32 @@ func main() {
 							filterArn = jobArn
 						}
 
-						artifactType := c.String("type")
-						listArtifacts(svc, filterArn, artifactType)
+						filter := newArtifactFilter(c.StringSlice("type"), c.StringSlice("include"), c.StringSlice("exclude"))
+						listArtifacts(svc, filterArn, filter)
 						return nil
 					},
 				},
+				{
+					Name:  "crashes",
+					Usage: "list the crash signatures kept in a crash history file",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "crash-history",
+							EnvVars: []string{"DF_CRASH_HISTORY"},
+							Usage:   "crash history file written by report --crash-history",
+						},
+						&cli.DurationFlag{
+							Name:  "since",
+							Usage: "only list the crashes seen within this duration (e.g. 168h)",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.String("crash-history") == "" {
+							return errors.New("we need a crash history file, use --crash-history")
+						}
+						return listCrashHistory(c.String("crash-history"), c.Duration("since"))
+					},
+				},
 				{
 					Name:  "suites",
 					Usage: "list the suites",
@@ -215,17 +438,55 @@ func main() {
 				{
 					Name: "problems",
 					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "project",
+							EnvVars: []string{"DF_PROJECT"},
+							Usage:   "project Arn or project description",
+						},
 						&cli.StringFlag{
 							Name:    "run",
 							EnvVars: []string{"DF_RUN"},
 							Usage:   "run Arn or run description",
 						},
+						&cli.StringFlag{
+							Name:  "from",
+							Usage: "list the problems of a run exported with export run",
+						},
+						&cli.StringFlag{
+							Name:  "sort",
+							Usage: "comma separated columns to sort on, prefix with - to sort descending [" + strings.Join(problemColumnNames(), ",") + "]",
+							Value: "result,-count",
+						},
+						&cli.IntFlag{
+							Name:  "max-affected",
+							Usage: "number of affected tests shown per problem, 0 for all",
+							Value: 10,
+						},
+						&cli.StringFlag{
+							Name:  "json",
+							Usage: "write the problems to a json file, - for stdout",
+						},
 					},
 					Usage: "list the problems", // of Test
 					Action: func(c *cli.Context) error {
-						runArn := c.String("run")
-						listUniqueProblems(svc, runArn)
-						return nil
+						options := problemOptions{
+							From:         c.String("from"),
+							Sort:         strings.Split(c.String("sort"), ","),
+							MaxLocations: c.Int("max-affected"),
+							JSON:         c.String("json"),
+						}
+						runArn := ""
+						if options.From == "" {
+							var err error
+							runArn, err = lookupRunArn(svc, c.String("project"), c.String("run"))
+							if err != nil {
+								return err
+							}
+							if runArn == "" {
+								return errors.New("we need a run, use --run or --from")
+							}
+						}
+						return listProblems(svc, runArn, options)
 					},
 				},
 				{
@@ -292,10 +553,41 @@ func main() {
 							EnvVars: []string{"DF_JOB"},
 							Usage:   "job Arn or run description",
 						},
-						&cli.StringFlag{
+						&cli.StringSliceFlag{
 							Name:    "type",
 							EnvVars: []string{"DF_ARTIFACT_TYPE"},
-							Usage:   "type of the artifact [LOG,FILE,SCREENSHOT]",
+							Usage:   "categories [LOG,FILE,SCREENSHOT] or types [VIDEO,DEVICE_LOG,CUSTOMER_ARTIFACT,TESTSPEC_OUTPUT,...] of the artifacts",
+						},
+						&cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "only artifacts with a name or name.extension matching the glob (\"*.mp4\")",
+						},
+						&cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "skip artifacts with a name or name.extension matching the glob",
+						},
+						&cli.StringFlag{
+							Name:    "out-dir",
+							EnvVars: []string{"DF_OUT_DIR"},
+							Usage:   "directory to download the artifacts to",
+							Value:   "report",
+						},
+						&cli.StringFlag{
+							Name:    "layout",
+							EnvVars: []string{"DF_LAYOUT"},
+							Usage:   "path of each artifact within --out-dir, using {run} {job} {device} {model} {os} {platform} {suite} {test} {type} {category} {name} {ext} {index}",
+							Value:   defaultDownloadLayout,
+						},
+						&cli.BoolFlag{
+							Name:    "manifest",
+							EnvVars: []string{"DF_MANIFEST"},
+							Usage:   "write manifest.json with the origin, size and sha256 of every artifact in --out-dir, on by default (--manifest=false to skip it)",
+							Value:   true,
+						},
+						&cli.StringFlag{
+							Name:    "archive",
+							EnvVars: []string{"DF_ARCHIVE"},
+							Usage:   "pack --out-dir into a .tar.gz or .zip archive",
 						},
 					},
 					Action: func(c *cli.Context) error {
@@ -309,9 +601,182 @@ func main() {
 							filterArn = jobArn
 						}
 
-						artifactType := c.String("type")
-						downloadArtifacts(svc, filterArn, artifactType)
-						return nil
+						filter := newArtifactFilter(c.StringSlice("type"), c.StringSlice("include"), c.StringSlice("exclude"))
+						outDir := c.String("out-dir")
+						layout := c.String("layout")
+						writeManifestFile := c.Bool("manifest")
+						archive := c.String("archive")
+						return downloadArtifacts(svc, filterArn, filter, outDir, layout, writeManifestFile, archive)
+					},
+				},
+			},
+		},
+		{
+			Name:  "stop",
+			Usage: "stop runs, jobs and remote access sessions",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "run",
+					Usage: "stop a run",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "project",
+							EnvVars: []string{"DF_PROJECT"},
+							Usage:   "project Arn or project name",
+						},
+						&cli.StringFlag{
+							Name:    "run",
+							EnvVars: []string{"DF_RUN"},
+							Usage:   "run Arn or run name",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						runArn, err := lookupRunArn(svc, c.String("project"), c.String("run"))
+						if err != nil {
+							return err
+						}
+						return stopRun(svc, runArn)
+					},
+				},
+				{
+					Name:  "job",
+					Usage: "stop a job",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "project",
+							EnvVars: []string{"DF_PROJECT"},
+							Usage:   "project Arn or project name",
+						},
+						&cli.StringFlag{
+							Name:    "run",
+							EnvVars: []string{"DF_RUN"},
+							Usage:   "run Arn or run name",
+						},
+						&cli.StringFlag{
+							Name:    "job",
+							EnvVars: []string{"DF_JOB"},
+							Usage:   "job Arn, job name or device name",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						runArn, err := lookupRunArn(svc, c.String("project"), c.String("run"))
+						if err != nil {
+							return err
+						}
+						jobArn, err := lookupJobArn(svc, runArn, c.String("job"))
+						if err != nil {
+							return err
+						}
+						return stopJob(svc, jobArn)
+					},
+				},
+				{
+					Name:  "session",
+					Usage: "stop a remote access session",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "project",
+							EnvVars: []string{"DF_PROJECT"},
+							Usage:   "project Arn or project name",
+						},
+						&cli.StringFlag{
+							Name:    "session",
+							EnvVars: []string{"DF_SESSION"},
+							Usage:   "remote access session Arn or name",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						sessionArn, err := lookupSessionArn(svc, c.String("project"), c.String("session"))
+						if err != nil {
+							return err
+						}
+						return stopSession(svc, sessionArn)
+					},
+				},
+			},
+		},
+		{
+			Name:  "logs",
+			Usage: "print the logs of a job",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "project",
+					EnvVars: []string{"DF_PROJECT"},
+					Usage:   "project Arn or project name",
+				},
+				&cli.StringFlag{
+					Name:    "run",
+					EnvVars: []string{"DF_RUN"},
+					Usage:   "run Arn or run name",
+				},
+				&cli.StringFlag{
+					Name:    "job",
+					EnvVars: []string{"DF_JOB"},
+					Usage:   "job Arn, job name or device name",
+				},
+				&cli.StringSliceFlag{
+					Name:  "type",
+					Usage: "artifact types to print [" + strings.Join(logTypes, ",") + ",...], all logs if not set",
+				},
+				&cli.StringFlag{
+					Name:  "grep",
+					Usage: "only print the lines matching this regular expression",
+				},
+				&cli.BoolFlag{
+					Name:  "follow",
+					Usage: "keep printing new logs until the job completes",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "how often to look for new logs with --follow",
+					Value: 10 * time.Second,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("job") == "" {
+					return errors.New("we need a job, use --job")
+				}
+				runArn, err := lookupRunArn(svc, c.String("project"), c.String("run"))
+				if err != nil {
+					return err
+				}
+				jobArn, err := lookupJobArn(svc, runArn, c.String("job"))
+				if err != nil {
+					return err
+				}
+				return showLogs(svc, jobArn, c.StringSlice("type"), c.String("grep"), c.Bool("follow"), c.Duration("interval"))
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "export devicefarm elements",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "run",
+					Usage: "export a run with its jobs, suites, tests, problems and artifacts as JSON",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "run",
+							EnvVars: []string{"DF_RUN"},
+							Usage:   "run Arn or run description",
+						},
+						&cli.StringFlag{
+							Name:  "out",
+							Usage: "path of the JSON file to write",
+							Value: "run.json",
+						},
+						&cli.IntFlag{
+							Name:    "concurrency",
+							EnvVars: []string{"DF_CONCURRENCY"},
+							Usage:   "number of jobs crawled at the same time",
+							Value:   4,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						runArn := c.String("run")
+						fileName := c.String("out")
+						concurrency := c.Int("concurrency")
+						return exportRun(svc, runArn, fileName, concurrency)
 					},
 				},
 			},
@@ -335,23 +800,155 @@ func main() {
 		{
 			Name:  "report",
 			Usage: "get report about a run",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:    "run",
 					EnvVars: []string{"DF_RUN"},
 					Usage:   "run Arn or run description",
 				},
-			},
+				&cli.StringFlag{
+					Name:  "from",
+					Usage: "render the report offline from a run exported with export run",
+				},
+			}, reportFlags()...),
 			Action: func(c *cli.Context) error {
 				runArn := c.String("run")
-				runReport(svc, runArn)
-				return nil
+				options := reportOptionsFromContext(c)
+				options.From = c.String("from")
+				options.redirectProgress()
+				return runReport(svc, runArn, options)
+			},
+		},
+		{
+			Name:  "screenshots",
+			Usage: "work with the screenshots of runs",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "diff",
+					Usage: "compare the screenshots of a run with a baseline",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "project",
+							EnvVars: []string{"DF_PROJECT"},
+							Usage:   "project Arn or project description",
+						},
+						&cli.StringFlag{
+							Name:  "baseline",
+							Usage: "report folder, or run Arn or run description, to compare with",
+						},
+						&cli.StringFlag{
+							Name:    "current",
+							EnvVars: []string{"DF_RUN"},
+							Usage:   "run Arn or run description, or report folder, to check",
+						},
+						&cli.StringFlag{
+							Name:  "out-dir",
+							Usage: "directory to download the screenshots of runs and write the diff images to",
+							Value: "screenshots",
+						},
+						&cli.Float64Flag{
+							Name:  "threshold",
+							Usage: "share of the screenshot that may differ before it fails, from 0 to 1",
+							Value: 0.01,
+						},
+						&cli.Float64Flag{
+							Name:  "pixel-tolerance",
+							Usage: "how much a pixel may differ before it counts as changed, from 0 to 1",
+							Value: 0.1,
+						},
+						&cli.StringFlag{
+							Name:  "metric",
+							Usage: "difference the threshold applies to [pixel,perceptual]",
+							Value: "pixel",
+						},
+						&cli.BoolFlag{
+							Name:  "allow-removed",
+							Usage: "do not fail on screenshots of the baseline missing from the current run",
+						},
+						&cli.StringFlag{
+							Name:  "json",
+							Usage: "write the differences to a json file",
+						},
+						&cli.IntFlag{
+							Name:    "concurrency",
+							EnvVars: []string{"DF_CONCURRENCY"},
+							Usage:   "number of screenshots downloaded and compared at the same time",
+							Value:   4,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.String("baseline") == "" || c.String("current") == "" {
+							return errors.New("we need screenshots to compare, use --baseline and --current")
+						}
+						options := screenshotOptions{
+							OutDir:         c.String("out-dir"),
+							Threshold:      c.Float64("threshold"),
+							PixelTolerance: c.Float64("pixel-tolerance"),
+							Metric:         c.String("metric"),
+							AllowRemoved:   c.Bool("allow-removed"),
+							Concurrency:    c.Int("concurrency"),
+							JSON:           c.String("json"),
+						}
+						return diffScreenshots(svc, c.String("project"), c.String("baseline"), c.String("current"), options)
+					},
+				},
+			},
+		},
+		{
+			Name:  "compare",
+			Usage: "compare the devices and tests of two runs",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "project",
+					EnvVars: []string{"DF_PROJECT"},
+					Usage:   "project Arn or project description",
+				},
+				&cli.StringFlag{
+					Name:  "base",
+					Usage: "run Arn, run description or run exported with export run to compare with",
+				},
+				&cli.StringFlag{
+					Name:    "head",
+					EnvVars: []string{"DF_RUN"},
+					Usage:   "run Arn, run description or run exported with export run to check",
+				},
+				&cli.StringFlag{
+					Name:  "markdown",
+					Usage: "path of the Markdown comparison to write, - for stdout",
+				},
+				&cli.StringFlag{
+					Name:  "json",
+					Usage: "path of the json comparison to write, - for stdout",
+				},
+				&cli.BoolFlag{
+					Name:  "fail-on-regression",
+					Usage: "fail when tests fail in --head that did not fail in --base, new tests included",
+				},
+				&cli.IntFlag{
+					Name:    "concurrency",
+					EnvVars: []string{"DF_CONCURRENCY"},
+					Usage:   "number of jobs crawled at the same time",
+					Value:   4,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("base") == "" || c.String("head") == "" {
+					return errors.New("we need two runs to compare, use --base and --head")
+				}
+				options := compareOptions{
+					Concurrency:      c.Int("concurrency"),
+					Markdown:         c.String("markdown"),
+					JSON:             c.String("json"),
+					FailOnRegression: c.Bool("fail-on-regression"),
+				}
+				options.redirectProgress()
+				return compare(svc, c.String("project"), c.String("base"), c.String("head"), options)
 			},
 		},
 		{
 			Name:  "schedule",
 			Usage: "schedule a run",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:    "project",
 					EnvVars: []string{"DF_PROJECT"},
@@ -365,7 +962,12 @@ func main() {
 				&cli.StringFlag{
 					Name:    "device",
 					EnvVars: []string{"DF_DEVICE"},
-					Usage:   "device Arn or devicepool name to run the test on",
+					Usage:   "device Arn, name or model to run the test on, optionally with os constraints (\"Galaxy S21@>=12\")",
+				},
+				&cli.StringFlag{
+					Name:    "platform",
+					EnvVars: []string{"DF_PLATFORM"},
+					Usage:   "platform of the device [ANDROID,IOS], guessed from the app type if not set",
 				},
 				&cli.StringFlag{
 					Name:    "name",
@@ -412,11 +1014,22 @@ func main() {
 					Usage:   "Arn or name of the app upload to schedule",
 					EnvVars: []string{"DF_APP"},
 				},
-			},
+				&cli.BoolFlag{
+					Name:    "warn-incompatible",
+					EnvVars: []string{"DF_WARN_INCOMPATIBLE"},
+					Usage:   "only warn instead of aborting when no device in the devicepool is compatible",
+				},
+				&cli.BoolFlag{
+					Name:    "stop-on-interrupt",
+					EnvVars: []string{"DF_STOP_ON_INTERRUPT"},
+					Usage:   "stop the run on devicefarm on Ctrl-C without asking",
+				},
+			}, reportFlags()...),
 			Action: func(c *cli.Context) error {
 				projectArn := c.String("project")
 				runName := c.String("name")
 				deviceArn := c.String("device")
+				platform := c.String("platform")
 				devicePoolArn := c.String("device-pool")
 				appArn := c.String("app")
 				appFile := c.String("app-file")
@@ -426,7 +1039,11 @@ func main() {
 				testPackageFile := c.String("test-file")
 				testSpecArn := c.String("test-spec")
 				testSpecFile := c.String("test-spec-file")
-				return scheduleRun(svc, projectArn, runName, deviceArn, devicePoolArn, appArn, appFile, appType, testPackageArn, testPackageFile, testPackageType, testSpecArn, testSpecFile)
+				warnIncompatible := c.Bool("warn-incompatible")
+				stopOnInterrupt := c.Bool("stop-on-interrupt")
+				options := reportOptionsFromContext(c)
+				options.redirectProgress()
+				return scheduleRun(svc, projectArn, runName, deviceArn, platform, devicePoolArn, appArn, appFile, appType, testPackageArn, testPackageFile, testPackageType, testSpecArn, testSpecFile, warnIncompatible, stopOnInterrupt, options)
 			},
 		},
 		{
@@ -481,6 +1098,27 @@ func main() {
 						return nil
 					},
 				},
+				{
+					Name:  "device",
+					Usage: "get all attributes and instances of a device",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "device",
+							EnvVars: []string{"DF_DEVICE"},
+							Usage:   "device Arn, name or model, optionally with os constraints (\"Galaxy S21@>=12\")",
+						},
+						&cli.StringFlag{
+							Name:    "platform",
+							EnvVars: []string{"DF_PLATFORM"},
+							Usage:   "platform of the device [ANDROID,IOS]",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						deviceName := c.String("device")
+						platform := c.String("platform")
+						return deviceInfo(svc, deviceName, platform)
+					},
+				},
 				{
 					Name:  "upload",
 					Usage: "info about uploads",
@@ -546,35 +1184,48 @@ func main() {
 }
 
 // --- internal API starts here
-func lookupDeviceArn(svc *devicefarm.DeviceFarm, deviceName string) (deviceArn string, err error) {
+func lookupDeviceArn(svc *devicefarm.DeviceFarm, deviceName string, platform string) (deviceArn string, err error) {
 
-	input := &devicefarm.ListDevicesInput{}
-	resp, err := svc.ListDevices(input)
+	query, err := parseDeviceQuery(deviceName, platform)
+	if err != nil {
+		return "", err
+	}
 
-	failOnErr(err, "error listing devices")
-	//fmt.Println(awsutil.Prettify(resp))
+	// No need to list the devices when we already have an Arn, devicefarm checks its platform
+	if isArn(query.Text) && len(query.Os) == 0 {
+		return query.Text, nil
+	}
 
-	devices := make(map[string]string)
-	for _, m := range resp.Devices {
-		key := fmt.Sprintf("%s - %s", *m.Name, *m.Os)
-		devices[key] = *m.Arn
-		//line := []string{*m.Name, *m.Os, *m.Platform, *m.FormFactor, *m.Arn}
+	devices, err := cachedDevices(svc)
+	if err != nil {
+		return "", err
 	}
 
-	Arn := devices[deviceName]
+	candidates := matchDevices(devices, query)
+	if len(candidates) > 0 {
+		best := candidates[0].Device
+		if deviceFriendlyName(best) != deviceName {
+			fmt.Printf("- Matched device %s (%s)\n", deviceFriendlyName(best), *best.Arn)
+		}
+		return *best.Arn, nil
+	}
 
-	if Arn != "" {
-		return Arn, nil
+	message := "failed to find a device with name " + deviceName
+	suggestions := suggestDevices(devices, query, 5)
+	if len(suggestions) > 0 {
+		message += ", did you mean:\n  - " + strings.Join(suggestions, "\n  - ")
 	}
 
-	return "", errors.New("failed to find a device with name " + deviceName)
+	return "", errors.New(message)
 
 }
 
-func createPoolFromDevice(svc *devicefarm.DeviceFarm, poolName string, deviceName string, projectArn string) (poolArn string, poolErr error) {
+func createPoolFromDevice(svc *devicefarm.DeviceFarm, poolName string, deviceName string, platform string, projectArn string) (poolArn string, poolErr error) {
 
-	deviceArn, err := lookupDeviceArn(svc, deviceName)
-	failOnErr(err, "error looking up device")
+	deviceArn, err := lookupDeviceArn(svc, deviceName, platform)
+	if err != nil {
+		return "", err
+	}
 
 	fmt.Printf("creating %s", deviceArn)
 	req := &devicefarm.CreateDevicePoolInput{
@@ -635,49 +1286,98 @@ func listDevicePools(svc *devicefarm.DeviceFarm, projectArn string) {
 }
 
 /* List all Devices */
-func listDevices(svc *devicefarm.DeviceFarm) {
+func listDevices(svc *devicefarm.DeviceFarm, filter deviceFilter, sortBy []string, columnNames []string) error {
 
-	input := &devicefarm.ListDevicesInput{}
-	resp, err := svc.ListDevices(input)
+	columns, err := lookupDeviceColumns(columnNames)
+	if err != nil {
+		return err
+	}
 
+	resp, err := cachedDevices(svc)
 	failOnErr(err, "error listing devices")
-	//fmt.Println(awsutil.Prettify(resp))
+
+	devices := []*devicefarm.Device{}
+	for _, m := range resp {
+		if filter.matches(m) {
+			devices = append(devices, m)
+		}
+	}
+
+	err = sortDevices(devices, sortBy)
+	if err != nil {
+		return err
+	}
+
+	header := []string{}
+	for _, column := range columns {
+		header = append(header, column.Header)
+	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Os", "Platform", "Form", "Arn"})
+	table.SetHeader(header)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetColWidth(50)
 
-	for _, m := range resp.Devices {
-		line := []string{*m.Name, *m.Os, *m.Platform, *m.FormFactor, *m.Arn}
+	for _, m := range devices {
+		line := []string{}
+		for _, column := range columns {
+			line = append(line, column.Value(m))
+		}
 		table.Append(line)
 	}
 	table.Render() // Send output
 
-	/*
-	   	    {
-	         Arn: "Arn:aws:devicefarm:us-west-2::device:A0E6E6E1059E45918208DF75B2B7EF6C",
-	         CPU: {
-	           Architecture: "ARMv7",
-	           Clock: 2265,
-	           Frequency: "MHz"
-	         },
-	         FormFactor: "PHONE",
-	         HeapSize: 0,
-	         Image: "NA",
-	         Manufacturer: "LG",
-	         Memory: 17179869184,
-	         Model: "G2",
-	         Name: "LG G2 (Sprint)",
-	         Os: "4.2.2",
-	         Platform: "ANDROID",
-	         Resolution: {
-	           Height: 1920,
-	           Width: 1080
-	         }
-	       }
-	*/
+	return nil
+}
+
+/* Get Device Info */
+func deviceInfo(svc *devicefarm.DeviceFarm, deviceName string, platform string) error {
+
+	deviceArn, err := lookupDeviceArn(svc, deviceName, platform)
+	if err != nil {
+		return err
+	}
+
+	infoReq := &devicefarm.GetDeviceInput{
+		Arn: aws.String(deviceArn),
+	}
+
+	resp, err := svc.GetDevice(infoReq)
+	failOnErr(err, "error getting device info")
+
+	device := resp.Device
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(80)
 
+	for _, name := range deviceColumnNames() {
+		column := deviceColumns[name]
+		table.Append([]string{column.Header, column.Value(device)})
+	}
+	table.Append([]string{"Image", aws.StringValue(device.Image)})
+	table.Render() // Send output
+
+	if len(device.Instances) == 0 {
+		return nil
+	}
+
+	instances := tablewriter.NewWriter(os.Stdout)
+	instances.SetHeader([]string{"Udid", "Status", "Labels", "Profile", "Arn"})
+	instances.SetAlignment(tablewriter.ALIGN_LEFT)
+	instances.SetColWidth(80)
+
+	for _, m := range device.Instances {
+		profile := ""
+		if m.InstanceProfile != nil {
+			profile = aws.StringValue(m.InstanceProfile.Name)
+		}
+		line := []string{aws.StringValue(m.Udid), aws.StringValue(m.Status), strings.Join(aws.StringValueSlice(m.Labels), ","), profile, aws.StringValue(m.Arn)}
+		instances.Append(line)
+	}
+	instances.Render() // Send output
+
+	return nil
 }
 
 /* List all uploads */
@@ -731,19 +1431,6 @@ func listTests(svc *devicefarm.DeviceFarm, runArn string) {
 	fmt.Println(awsutil.Prettify(resp))
 }
 
-/* List all unique problems */
-func listUniqueProblems(svc *devicefarm.DeviceFarm, runArn string) {
-
-	listReq := &devicefarm.ListUniqueProblemsInput{
-		Arn: aws.String(runArn),
-	}
-
-	resp, err := svc.ListUniqueProblems(listReq)
-
-	failOnErr(err, "error listing problems")
-	fmt.Println(awsutil.Prettify(resp))
-}
-
 /* List suites */
 func listSuites(svc *devicefarm.DeviceFarm, filterArn string) {
 
@@ -860,7 +1547,7 @@ func lookupTestTypes(testType string) (testPackageType string, testSpecType stri
 }
 
 /* Schedule Run */
-func scheduleRun(svc *devicefarm.DeviceFarm, projectArn string, runName string, deviceArn string, devicePoolArn string, appArn string, appFile string, appType string, testPackageArn string, testPackageFile string, testType string, testSpecArn string, testSpecFile string) error {
+func scheduleRun(svc *devicefarm.DeviceFarm, projectArn string, runName string, deviceArn string, platform string, devicePoolArn string, appArn string, appFile string, appType string, testPackageArn string, testPackageFile string, testType string, testSpecArn string, testSpecFile string, warnIncompatible bool, stopOnInterrupt bool, options reportOptions) error {
 	debug := false
 
 	// Upload the app file if there is one
@@ -877,21 +1564,26 @@ func scheduleRun(svc *devicefarm.DeviceFarm, projectArn string, runName string,
 		}
 
 		// Upload appFile with correct AppType
-		fmt.Printf("- Uploading app-file %s of type %s ", appFile, appType)
+		fmt.Fprintf(progress, "- Uploading app-file %s of type %s ", appFile, appType)
 
 		uploadApp, err := uploadPut(svc, appFile, appType, projectArn, "")
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("\n")
+		fmt.Fprintf(progress, "\n")
 		appArn = *uploadApp.Arn
 	}
 
 	if devicePoolArn == "" {
 		if deviceArn != "" {
+			// Only look for devices that can run the app
+			if platform == "" && (appType == "ANDROID_APP" || appType == "IOS_APP") {
+				platform = strings.TrimSuffix(appType, "_APP")
+			}
+
 			// Try to create pool from device Arn
-			foundArn, err := createPoolFromDevice(svc, deviceArn, deviceArn, projectArn)
+			foundArn, err := createPoolFromDevice(svc, deviceArn, deviceArn, platform, projectArn)
 
 			if err != nil {
 				return err
@@ -914,29 +1606,36 @@ func scheduleRun(svc *devicefarm.DeviceFarm, projectArn string, runName string,
 		return err
 	}
 
+	// Check the devicepool before uploading tests and scheduling the run
+	fmt.Fprintln(progress, "- Checking devicepool compatibility")
+	err = checkDevicePool(svc, devicePoolArn, appArn, testType, warnIncompatible)
+	if err != nil {
+		return err
+	}
+
 	// Upload the testPackage file if there is one
 	if testPackageFile != "" {
 
-		fmt.Printf("- Uploading test-file %s of type %s ", testPackageFile, testPackageType)
+		fmt.Fprintf(progress, "- Uploading test-file %s of type %s ", testPackageFile, testPackageType)
 
 		uploadTestPackage, err := uploadPut(svc, testPackageFile, testPackageType, projectArn, "")
 		if err != nil {
 			return err
 		}
 		testPackageArn = *uploadTestPackage.Arn
-		fmt.Printf("\n")
+		fmt.Fprintf(progress, "\n")
 	}
 
 	// Upload the testSpec file if there is one
 	if testSpecFile != "" {
-		fmt.Printf("- Uploading test-spec-file %s of type %s ", testSpecFile, testSpecType)
+		fmt.Fprintf(progress, "- Uploading test-spec-file %s of type %s ", testSpecFile, testSpecType)
 
 		uploadTestSpec, err := uploadPut(svc, testSpecFile, testSpecType, projectArn, "")
 		if err != nil {
 			return err
 		}
 		testSpecArn = *uploadTestSpec.Arn
-		fmt.Printf("\n")
+		fmt.Fprintf(progress, "\n")
 	}
 
 	runTest := &devicefarm.ScheduleRunTest{
@@ -951,12 +1650,12 @@ func scheduleRun(svc *devicefarm.DeviceFarm, projectArn string, runName string,
 	}
 
 	if debug {
-		fmt.Println(appArn)
-		fmt.Println(devicePoolArn)
-		fmt.Println(runName)
-		fmt.Println(testPackageArn)
-		fmt.Println(testPackageType)
-		fmt.Println(projectArn)
+		fmt.Fprintln(progress, appArn)
+		fmt.Fprintln(progress, devicePoolArn)
+		fmt.Fprintln(progress, runName)
+		fmt.Fprintln(progress, testPackageArn)
+		fmt.Fprintln(progress, testPackageType)
+		fmt.Fprintln(progress, projectArn)
 	}
 
 	runReq := &devicefarm.ScheduleRunInput{
@@ -968,160 +1667,110 @@ func scheduleRun(svc *devicefarm.DeviceFarm, projectArn string, runName string,
 	}
 
 	if debug {
-		fmt.Println(awsutil.Prettify(runReq))
+		fmt.Fprintln(progress, awsutil.Prettify(runReq))
 	}
 
-	fmt.Println("- Initiating test run")
+	fmt.Fprintln(progress, "- Initiating test run")
 
 	resp, err := svc.ScheduleRun(runReq)
 	if err != nil {
 		return err
 	}
 
-	//fmt.Println(awsutil.Prettify(resp))
+	//fmt.Fprintln(progress, awsutil.Prettify(resp))
 
 	// Now we wait for the run status to go COMPLETED
-	fmt.Print("- Waiting until the tests complete ")
+	fmt.Fprint(progress, "- Waiting until the tests complete ")
 
 	runArn := *resp.Run.Arn
 
-	status := ""
-	for status != "COMPLETED" {
-		time.Sleep(4 * time.Second)
-		infoReq := &devicefarm.GetRunInput{
-			Arn: aws.String(runArn),
-		}
-
-		fmt.Print(".")
-		resp, err := svc.GetRun(infoReq)
-
-		if err != nil {
-			return err
-		}
-		status = *resp.Run.Status
+	err = waitForRun(svc, runArn, stopOnInterrupt)
+	if err != nil {
+		return err
 	}
 
 	// Generate report
-	fmt.Println("\n- Generating report ")
-	runReport(svc, runArn)
-
-	return nil
+	fmt.Fprintln(progress, "\n- Generating report ")
+	return runReport(svc, runArn, options)
 
 }
 
 /* List Artifacts */
 
-func listArtifacts(svc *devicefarm.DeviceFarm, filterArn string, artifactType string) {
-
-	fmt.Println(filterArn)
-
-	listReq := &devicefarm.ListArtifactsInput{
-		Arn: aws.String(filterArn),
-	}
-
-	listReq.Type = aws.String("LOG")
-	resp, err := svc.ListArtifacts(listReq)
-	failOnErr(err, "error listing artifacts")
-	fmt.Println(awsutil.Prettify(resp))
+func listArtifacts(svc *devicefarm.DeviceFarm, filterArn string, filter artifactFilter) {
 
-	listReq.Type = aws.String("SCREENSHOT")
-	resp, err = svc.ListArtifacts(listReq)
+	artifacts, err := listArtifactNodes(svc, filterArn, filter)
 	failOnErr(err, "error listing artifacts")
 
-	fmt.Println(awsutil.Prettify(resp))
-
-	listReq.Type = aws.String("FILE")
-	resp, err = svc.ListArtifacts(listReq)
-	failOnErr(err, "error listing artifacts")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Category", "Type", "Name", "Extension", "Arn"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(120)
 
-	fmt.Println(awsutil.Prettify(resp))
+	for _, m := range artifacts {
+		line := []string{m.Category, aws.StringValue(m.Artifact.Type), aws.StringValue(m.Artifact.Name), aws.StringValue(m.Artifact.Extension), aws.StringValue(m.Artifact.Arn)}
+		table.Append(line)
+	}
+	table.Render() // Send output
 }
 
 /* Download Artifacts */
-func downloadArtifacts(svc *devicefarm.DeviceFarm, filterArn string, artifactType string) {
+func downloadArtifacts(svc *devicefarm.DeviceFarm, filterArn string, filter artifactFilter, outDir string, layout string, writeManifestFile bool, archive string) error {
 
 	debug := false
 	if debug {
 		fmt.Println(filterArn)
 	}
 
-	listReq := &devicefarm.ListArtifactsInput{
-		Arn: aws.String(filterArn),
-	}
-
-	types := []string{"LOG", "SCREENSHOT", "FILE"}
-
-	for _, each := range types {
-		listReq.Type = aws.String(each)
-
-		resp, err := svc.ListArtifacts(listReq)
-		failOnErr(err, "error listing artifacts")
+	artifacts, err := listArtifactNodes(svc, filterArn, filter)
+	failOnErr(err, "error listing artifacts")
 
-		for index, artifact := range resp.Artifacts {
-			fileName := fmt.Sprintf("- report/%d-%s.%s", index, *artifact.Name, *artifact.Extension)
-			downloadArtifact(fileName, artifact)
+	d := newDownloader(svc)
+	paths := newPathAllocator()
+	names := newArnNames(svc)
+	counts := map[string]int{}
+	entries := []manifestEntry{}
+	for _, artifact := range artifacts {
+		fields := newArtifactFields(artifact, counts[artifact.Category])
+		counts[artifact.Category]++
+
+		// Only look up the names when the layout or the manifest uses them
+		if layoutNeedsHierarchy(layout) || writeManifestFile {
+			err := names.fill(&fields, aws.StringValue(artifact.Artifact.Arn))
+			failOnErr(err, "error looking up artifact names")
 		}
-	}
-
-}
-
-func downloadArtifact(fileName string, artifact *devicefarm.Artifact) {
 
-	url := *artifact.Url
-
-	dirName := path.Dir(fileName)
-	err := os.MkdirAll(dirName, 0777)
-
-	if err != nil {
-		fmt.Println(err)
-		panic(err)
-	}
-
-	//fmt.Printf("Downloading [%s] -> [%s]\n", url, fileName)
-
-	downloadURL(url, fileName)
-}
-
-func downloadURL(url string, fileName string) {
-
-	file, err := os.Create(fileName)
-
-	if err != nil {
-		fmt.Println(err)
-		panic(err)
-	}
-	defer file.Close()
-
-	check := http.Client{
-		CheckRedirect: func(r *http.Request, via []*http.Request) error {
-			r.URL.Opaque = r.URL.Path
-			return nil
-		},
-	}
-
-	resp, err := check.Get(url) // add a filter to check redirect
-
-	if err != nil {
-		fmt.Println(err)
-		panic(err)
-	}
-	defer resp.Body.Close()
-	debug := false
+		fileName := paths.allocate(filepath.Join(outDir, renderLayout(layout, fields)))
+		err := d.downloadArtifact(fileName, artifact.Artifact)
+		if err != nil {
+			fmt.Printf("- [%s] %s failed: %s\n", artifact.Category, fileName, err)
+			continue
+		}
+		fmt.Printf("- [%s] %s\n", artifact.Category, fileName)
 
-	if debug {
-		fmt.Println(resp.Status)
+		artifact.Path = fileName
+		entries = append(entries, newManifestEntry(artifact, fields))
 	}
 
-	size, err := io.Copy(file, resp.Body)
+	d.printSummary()
 
-	if err != nil {
-		panic(err)
+	if writeManifestFile {
+		fileName, err := writeManifest(outDir, entries)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("- [MANIFEST] %s\n", fileName)
 	}
 
-	if debug {
-		fmt.Printf("%s with %v bytes downloaded", fileName, size)
+	if archive != "" {
+		err := writeArchive(outDir, archive)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("- [ARCHIVE] %s\n", archive)
 	}
 
+	return d.err()
 }
 
 /* List Jobs */
@@ -1165,97 +1814,359 @@ func runInfo(svc *devicefarm.DeviceFarm, runArn string) {
 	fmt.Println(awsutil.Prettify(resp))
 }
 
-/* Get Run Report */
-func runReport(svc *devicefarm.DeviceFarm, runArn string) {
+// reportOptions are the extra outputs the report command writes
+type reportOptions struct {
+	OutDir       string
+	Layout       string
+	Manifest     bool
+	Archive      string
+	MergeResults bool
+	PerfCSV      string
+	PerfBudgets  []string
+	LogTags      []string
+	Crashes      string
+	CrashHistory string
+	Concurrency  int
+	From         string
+	Export       string
+	JUnit        string
+	HTML         string
+	Markdown     string
+	AllureDir    string
+}
 
-	infoReq := &devicefarm.GetRunInput{
-		Arn: aws.String(runArn),
+// reportFlags are shared by the report and schedule commands
+func reportFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "out-dir",
+			EnvVars: []string{"DF_OUT_DIR"},
+			Usage:   "directory to download the artifacts to",
+			Value:   "report",
+		},
+		&cli.StringFlag{
+			Name:    "layout",
+			EnvVars: []string{"DF_LAYOUT"},
+			Usage:   "path of each artifact within --out-dir, using {run} {job} {device} {model} {os} {platform} {suite} {test} {type} {category} {name} {ext} {index}",
+			Value:   defaultReportLayout,
+		},
+		&cli.BoolFlag{
+			Name:    "manifest",
+			EnvVars: []string{"DF_MANIFEST"},
+			Usage:   "write manifest.json with the origin, size and sha256 of every artifact and sample in --out-dir, off by default as hashing every file takes a while on big runs",
+		},
+		&cli.StringFlag{
+			Name:    "archive",
+			EnvVars: []string{"DF_ARCHIVE"},
+			Usage:   "pack --out-dir into a .tar.gz or .zip archive",
+		},
+		&cli.BoolFlag{
+			Name:    "merge-results",
+			EnvVars: []string{"DF_MERGE_RESULTS"},
+			Usage:   "merge the JUnit and TestNG result files found in the customer artifacts into the reports",
+			Value:   true,
+		},
+		&cli.StringFlag{
+			Name:  "perf-csv",
+			Usage: "write the performance samples (cpu, memory, fps, threads, ...) of every device as CSV",
+		},
+		&cli.StringSliceFlag{
+			Name:    "perf-budget",
+			EnvVars: []string{"DF_PERF_BUDGET"},
+			Usage:   "fail when a device exceeds a performance budget, e.g. CPU.p95<=80,MEMORY<=400000,FPS.avg>=30",
+		},
+		&cli.StringSliceFlag{
+			Name:  "log-tag",
+			Usage: "also collect the device log lines with these tags (logcat) or processes (iOS)",
+		},
+		&cli.StringFlag{
+			Name:  "crashes",
+			Usage: "write the crashes, ANRs and tagged lines found in the device logs as JSON",
+		},
+		&cli.StringFlag{
+			Name:    "crash-history",
+			EnvVars: []string{"DF_CRASH_HISTORY"},
+			Usage:   "file to keep the crash signatures of all reported runs in, to tell new crashes from known ones",
+		},
+		&cli.IntFlag{
+			Name:    "concurrency",
+			EnvVars: []string{"DF_CONCURRENCY"},
+			Usage:   "number of jobs crawled and artifacts downloaded at the same time",
+			Value:   4,
+		},
+		&cli.StringFlag{
+			Name:    "export",
+			EnvVars: []string{"DF_EXPORT"},
+			Usage:   "path of the JSON run export to write, to render the report again with --from",
+		},
+		&cli.StringFlag{
+			Name:    "junit",
+			EnvVars: []string{"DF_JUNIT"},
+			Usage:   "path of the JUnit XML file to write",
+		},
+		&cli.StringFlag{
+			Name:    "html",
+			EnvVars: []string{"DF_HTML"},
+			Usage:   "path of the HTML report to write (report/index.html)",
+		},
+		&cli.StringFlag{
+			Name:    "markdown",
+			EnvVars: []string{"DF_MARKDOWN"},
+			Usage:   "path of the Markdown summary to write, - for stdout",
+		},
+		&cli.StringFlag{
+			Name:    "allure-dir",
+			EnvVars: []string{"DF_ALLURE_DIR"},
+			Usage:   "directory to write the Allure results to",
+		},
 	}
+}
 
-	resp, err := svc.GetRun(infoReq)
+func reportOptionsFromContext(c *cli.Context) reportOptions {
+	return reportOptions{
+		OutDir:       c.String("out-dir"),
+		Layout:       c.String("layout"),
+		Manifest:     c.Bool("manifest"),
+		Archive:      c.String("archive"),
+		MergeResults: c.Bool("merge-results"),
+		PerfCSV:      c.String("perf-csv"),
+		PerfBudgets:  c.StringSlice("perf-budget"),
+		LogTags:      c.StringSlice("log-tag"),
+		Crashes:      c.String("crashes"),
+		CrashHistory: c.String("crash-history"),
+		Concurrency:  c.Int("concurrency"),
+		Export:       c.String("export"),
+		JUnit:        c.String("junit"),
+		HTML:         c.String("html"),
+		Markdown:     c.String("markdown"),
+		AllureDir:    c.String("allure-dir"),
+	}
+}
 
-	failOnErr(err, "error getting run info")
+/* Get Run Report */
+func runReport(svc *devicefarm.DeviceFarm, runArn string, options reportOptions) error {
 
-	fmt.Printf("Reporting on run %s\n", *resp.Run.Name)
-	//fmt.Println(awsutil.Prettify(resp))
+	var tree *runTree
+	var err, downloadErr error
 
-	jobReq := &devicefarm.ListJobsInput{
-		Arn: aws.String(runArn),
+	// Check the budgets before spending time on the run
+	budgets, err := parsePerfBudgets(options.PerfBudgets)
+	if err != nil {
+		return err
 	}
 
-	// Find all artifacts
-	artifactReq := &devicefarm.ListArtifactsInput{
-		Arn: aws.String(runArn),
-	}
+	if options.From != "" {
+		// Render offline, only link the artifacts that were downloaded before
+		tree, err = loadRunTree(options.From)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(progress, "Reporting on run %s from %s\n", *tree.Run.Name, options.From)
+		assignArtifactPaths(tree, options.OutDir, options.Layout)
+		for _, artifact := range runArtifacts(tree) {
+			if _, err := os.Stat(artifact.Path); err != nil {
+				artifact.Path = ""
+			}
+		}
+		for _, job := range tree.Jobs {
+			for _, sample := range job.Samples {
+				if _, err := os.Stat(sample.Path); err != nil {
+					sample.Path = ""
+				}
+			}
+		}
+	} else {
+		// Samples are only listed and downloaded for the performance reports
+		crawl := crawlJob
+		if options.PerfCSV != "" || len(budgets) > 0 {
+			crawl = crawlJobWithSamples
+		}
+		tree, err = crawlRunJobs(svc, runArn, options.Concurrency, crawl)
+		failOnErr(err, "error getting run info")
 
-	types := []string{"LOG", "SCREENSHOT", "FILE"}
-	artifacts := map[string][]devicefarm.ListArtifactsOutput{}
+		fmt.Fprintf(progress, "Reporting on run %s\n", *tree.Run.Name)
+		assignArtifactPaths(tree, options.OutDir, options.Layout)
+		// The reports are still written without the artifacts that failed
+		downloadErr = downloadRunArtifacts(svc, tree, options.Concurrency)
+
+		if options.Manifest {
+			fileName, err := writeManifest(options.OutDir, manifestFromTree(tree))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(progress, "- [MANIFEST] %s\n", fileName)
+		}
+	}
 
-	for _, artifactType := range types {
+	if options.MergeResults {
+		merged, err := mergeCustomerResults(tree)
+		if err != nil {
+			return err
+		}
+		if merged > 0 {
+			fmt.Fprintf(progress, "- Merged %d tests from the customer artifacts\n", merged)
+		}
+	}
 
-		artifactReq.Type = aws.String(artifactType)
+	err = analyzeDeviceLogs(tree, options.LogTags)
+	if err != nil {
+		return err
+	}
+	tree.Crashes = groupCrashes(tree)
+	if options.CrashHistory != "" {
+		err := updateCrashHistory(tree.Crashes, options.CrashHistory)
+		if err != nil {
+			return err
+		}
+	}
+	printCrashGroups(tree, tree.Crashes)
 
-		artifactResp, err := svc.ListArtifacts(artifactReq)
-		failOnErr(err, "error getting run info")
+	err = analyzeTestSpecs(tree)
+	if err != nil {
+		return err
+	}
+	printTestSpecFailures(tree)
 
-		// Store type artifacts
-		artifacts[artifactType] = append(artifacts[artifactType], *artifactResp)
+	err = analyzeSamples(tree)
+	if err != nil {
+		return err
 	}
+	printPerf(tree)
+	violations := checkPerfBudgets(tree, budgets)
 
-	respJob, err := svc.ListJobs(jobReq)
-	failOnErr(err, "error getting jobs")
+	if options.PerfCSV != "" {
+		err := writePerfCSV(tree, options.PerfCSV)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "- [PERF] %s\n", options.PerfCSV)
+	}
 
-	// Find all jobs within this run
-	for _, job := range respJob.Jobs {
+	if options.Crashes != "" {
+		err := writeLogEvents(tree, options.Crashes)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "- [CRASHES] %s\n", options.Crashes)
+	}
 
-		//fmt.Println("==========================================")
-		time.Sleep(2 * time.Second)
+	if options.Export != "" {
+		err := writeRunTree(tree, options.Export)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "- [EXPORT] %s\n", options.Export)
+	}
 
-		jobFriendlyName := fmt.Sprintf("%s - %s - %s", *job.Name, *job.Device.Model, *job.Device.Os)
+	if options.JUnit != "" {
+		err := writeJUnitReport(tree, options.JUnit)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "- [JUNIT] %s\n", options.JUnit)
+	}
 
-		//fmt.Println(awsutil.Prettify(job))
+	if options.HTML != "" {
+		err := writeHTMLReport(tree, options.HTML)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "- [HTML] %s\n", options.HTML)
+	}
 
-		suiteReq := &devicefarm.ListSuitesInput{
-			Arn: aws.String(*job.Arn),
+	if options.Markdown != "" {
+		err := writeMarkdownReport(tree, options.Markdown)
+		if err != nil {
+			return err
 		}
-		suiteResp, err := svc.ListSuites(suiteReq)
-		failOnErr(err, "error getting run info")
+		if options.Markdown != "-" {
+			fmt.Fprintf(progress, "- [MARKDOWN] %s\n", options.Markdown)
+		}
+	}
 
-		for _, suite := range suiteResp.Suites {
-			message := ""
-			if suite.Message != nil {
-				message = *suite.Message
-			}
+	if options.AllureDir != "" {
+		err := writeAllureResults(tree, options.AllureDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "- [ALLURE] %s\n", options.AllureDir)
+	}
 
-			debug := false
-			if debug {
-				fmt.Printf("%s -> %s : %s \n----> %s\n", jobFriendlyName, *suite.Name, message, *suite.Arn)
-			}
-			dirPrefix := fmt.Sprintf("report/%s/%s", jobFriendlyName, *suite.Name)
-			downloadArtifactsForSuite(dirPrefix, artifacts, *suite)
+	if options.Archive != "" {
+		err := writeArchive(options.OutDir, options.Archive)
+		if err != nil {
+			return err
 		}
+		fmt.Fprintf(progress, "- [ARCHIVE] %s\n", options.Archive)
+	}
+
+	if downloadErr != nil {
+		return downloadErr
+	}
 
-		//fmt.Println(awsutil.Prettify(suiteResp))
+	// The reports are written first, they tell what went over budget
+	if violations > 0 {
+		return fmt.Errorf("%d performance budgets exceeded", violations)
 	}
 
+	return nil
 }
 
-func downloadArtifactsForSuite(dirPrefix string, allArtifacts map[string][]devicefarm.ListArtifactsOutput, suite devicefarm.Suite) {
-	suiteArn := *suite.Arn
-	artifactTypes := []string{"LOG", "SCREENSHOT", "FILE"}
+// assignArtifactPaths decides where every artifact of the run is downloaded to
+func assignArtifactPaths(tree *runTree, outDir string, layout string) {
+
+	paths := newPathAllocator()
 
-	r := strings.NewReplacer(":suite:", ":artifact:")
-	artifactPrefix := r.Replace(suiteArn)
+	for _, job := range tree.Jobs {
+		// Samples are stored as artifacts of the job in the SAMPLE category
+		for i, sample := range job.Samples {
+			fields := newArtifactFields(&artifactNode{Artifact: sampleArtifact(sample.Sample), Category: "SAMPLE"}, i)
+			fields.Run = aws.StringValue(tree.Run.Name)
+			fields.setJob(job.Job)
+
+			sample.Path = paths.allocate(filepath.Join(outDir, renderLayout(layout, fields)))
+		}
 
-	for _, artifactType := range artifactTypes {
-		typedArtifacts := allArtifacts[artifactType]
-		for _, artifactList := range typedArtifacts {
+		// Artifacts of the job itself have no suite or test
+		for _, artifactType := range artifactCategories {
 			count := 0
-			for _, artifact := range artifactList.Artifacts {
-				if strings.HasPrefix(*artifact.Arn, artifactPrefix) {
-					fileName := fmt.Sprintf("%s/%d_%s.%s", dirPrefix, count, *artifact.Name, *artifact.Extension)
-					fmt.Printf("- [%s] %s\n", artifactType, fileName)
-					downloadArtifact(fileName, artifact)
+			for _, artifact := range job.Artifacts {
+				if artifact.Category != artifactType {
+					continue
+				}
+
+				fields := newArtifactFields(artifact, count)
+				fields.Run = aws.StringValue(tree.Run.Name)
+				fields.setJob(job.Job)
+
+				artifact.Path = paths.allocate(filepath.Join(outDir, renderLayout(layout, fields)))
+				count++
+			}
+		}
+
+		for _, suite := range job.Suites {
+			tests := map[*artifactNode]string{}
+			for _, test := range suite.Tests {
+				for _, artifact := range test.Artifacts {
+					tests[artifact] = aws.StringValue(test.Test.Name)
+				}
+			}
+
+			for _, artifactType := range artifactCategories {
+				count := 0
+				for _, artifact := range suite.Artifacts {
+					if artifact.Category != artifactType {
+						continue
+					}
+
+					fields := newArtifactFields(artifact, count)
+					fields.Run = aws.StringValue(tree.Run.Name)
+					fields.setJob(job.Job)
+					fields.Suite = aws.StringValue(suite.Suite.Name)
+					fields.Test = tests[artifact]
+
+					artifact.Path = paths.allocate(filepath.Join(outDir, renderLayout(layout, fields)))
 					count++
 				}
 			}
@@ -1264,6 +2175,32 @@ func downloadArtifactsForSuite(dirPrefix string, allArtifacts map[string][]devic
 
 }
 
+// downloadRunArtifacts downloads every artifact of the run, the ones that failed
+// are not linked in the reports and make it return an error
+func downloadRunArtifacts(svc *devicefarm.DeviceFarm, tree *runTree, concurrency int) error {
+
+	d := newDownloader(svc)
+	artifacts := runArtifacts(tree)
+	errs := make([]error, len(artifacts))
+
+	forEachOrdered(len(artifacts), concurrency, func(i int) {
+		errs[i] = d.downloadArtifact(artifacts[i].Path, artifacts[i].Artifact)
+	}, func(i int) {
+		if errs[i] != nil {
+			fmt.Fprintf(progress, "- [%s] %s failed: %s\n", artifacts[i].Category, artifacts[i].Path, errs[i])
+			// Reports only link what is on disk
+			artifacts[i].Path = ""
+			return
+		}
+		fmt.Fprintf(progress, "- [%s] %s\n", artifacts[i].Category, artifacts[i].Path)
+	})
+
+	downloadRunSamples(d, tree, concurrency)
+
+	d.printSummary()
+	return d.err()
+}
+
 /* Get Run Status */
 func runStatus(svc *devicefarm.DeviceFarm, runArn string) {
 
@@ -1326,7 +2263,6 @@ func uploadPut(svc *devicefarm.DeviceFarm, uploadFilePath string, uploadType str
 
 	if err != nil {
 		return nil, err
-		fmt.Println(err)
 	}
 
 	defer file.Close()
@@ -1364,9 +2300,9 @@ func uploadPut(svc *devicefarm.DeviceFarm, uploadFilePath string, uploadType str
 	upload_url := *uploadInfo.Url
 
 	if debug {
-		fmt.Println("- Upload Response result:")
-		fmt.Println(awsutil.Prettify(uploadResp))
-		fmt.Println(upload_url)
+		fmt.Fprintln(progress, "- Upload Response result:")
+		fmt.Fprintln(progress, awsutil.Prettify(uploadResp))
+		fmt.Fprintln(progress, upload_url)
 	}
 
 	req, err := http.NewRequest("PUT", upload_url, fileBytes)
@@ -1386,7 +2322,7 @@ func uploadPut(svc *devicefarm.DeviceFarm, uploadFilePath string, uploadType str
 
 	// Debug Request to AWS
 	if debug {
-		fmt.Println("- HTTP Upload Request")
+		fmt.Fprintln(progress, "- HTTP Upload Request")
 		debugHTTP(httputil.DumpRequestOut(req, false))
 	}
 
@@ -1395,7 +2331,7 @@ func uploadPut(svc *devicefarm.DeviceFarm, uploadFilePath string, uploadType str
 	res, err := client.Do(req)
 
 	if debug {
-		fmt.Println("- HTTP Upload Response")
+		fmt.Fprintln(progress, "- HTTP Upload Response")
 		dump, _ := httputil.DumpResponse(res, true)
 		log.Printf("} -> %s\n", dump)
 	}
@@ -1409,7 +2345,7 @@ func uploadPut(svc *devicefarm.DeviceFarm, uploadFilePath string, uploadType str
 
 	status := ""
 	for status != "SUCCEEDED" {
-		fmt.Print(".")
+		fmt.Fprint(progress, ".")
 		time.Sleep(4 * time.Second)
 		uploadReq := &devicefarm.GetUploadInput{
 			Arn: uploadInfo.Arn,
@@ -1427,12 +2363,23 @@ func uploadPut(svc *devicefarm.DeviceFarm, uploadFilePath string, uploadType str
 	return uploadResp.Upload, nil
 }
 
+// progress receives the progress lines of report and schedule, it is stderr
+// when a report is written to stdout so the two do not mix
+var progress io.Writer = os.Stdout
+
+// redirectProgress sends the progress lines to stderr when stdout is taken by a report
+func (o reportOptions) redirectProgress() {
+	if o.Markdown == "-" {
+		progress = os.Stderr
+	}
+}
+
 /*
  * Helper page to exit on error with a nice message
  */
 func failOnErr(err error, reason string) {
 	if err != nil {
-		log.Fatal("Failed: %s, %s\n\n", reason, err)
+		log.Fatalf("Failed: %s, %s\n\n", reason, err)
 		os.Exit(-1)
 	}
 
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"github.com/urfave/cli/v2"
+	"testing"
+)
+
+// reportContext parses the arguments with the report flags, like the report command does
+func reportContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("report", flag.ContinueOnError)
+	for _, f := range reportFlags() {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestReportOptionsFromContext(t *testing.T) {
+
+	tests := []struct {
+		args  []string
+		check func(options reportOptions) bool
+	}{
+		{nil, func(o reportOptions) bool { return o.MergeResults }},
+		{[]string{"--merge-results=false"}, func(o reportOptions) bool { return !o.MergeResults }},
+		{nil, func(o reportOptions) bool { return o.PerfCSV == "" && len(o.PerfBudgets) == 0 }},
+		{[]string{"--perf-csv", "perf.csv"}, func(o reportOptions) bool { return o.PerfCSV == "perf.csv" }},
+		{[]string{"--perf-budget", "CPU.p95<=80", "--perf-budget", "FPS.avg>=30"}, func(o reportOptions) bool {
+			return len(o.PerfBudgets) == 2 && o.PerfBudgets[0] == "CPU.p95<=80" && o.PerfBudgets[1] == "FPS.avg>=30"
+		}},
+		{nil, func(o reportOptions) bool {
+			return o.OutDir == "report" && o.Layout == defaultReportLayout && o.Concurrency == 4
+		}},
+		{[]string{"--out-dir", "out", "--markdown", "-"}, func(o reportOptions) bool { return o.OutDir == "out" && o.Markdown == "-" }},
+	}
+
+	for _, test := range tests {
+		options := reportOptionsFromContext(reportContext(t, test.args...))
+		if !test.check(options) {
+			t.Errorf("options from %v are %+v", test.args, options)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of events found in device logs
+const (
+	eventFatalException    = "FATAL_EXCEPTION"
+	eventANR               = "ANR"
+	eventNativeCrash       = "NATIVE_CRASH"
+	eventUncaughtException = "UNCAUGHT_EXCEPTION"
+	eventCrash             = "CRASH"
+	eventWatchdog          = "WATCHDOG"
+	eventTag               = "TAG"
+)
+
+// maxEventStack keeps a runaway stack trace from swallowing the rest of the log
+const maxEventStack = 100
+
+// A logEvent is a crash, ANR or tagged line found in a device log
+type logEvent struct {
+	Kind      string   `json:"kind"`
+	Time      string   `json:"time"`
+	Process   string   `json:"process,omitempty"`
+	Pid       int      `json:"pid,omitempty"`
+	Tag       string   `json:"tag,omitempty"`
+	Level     string   `json:"level,omitempty"`
+	Exception string   `json:"exception,omitempty"`
+	Message   string   `json:"message"`
+	Stack     []string `json:"stack,omitempty"`
+	Suite     string   `json:"suite,omitempty"`
+	Log       string   `json:"log,omitempty"`
+	Line      int      `json:"line"`
+	Signature string   `json:"signature,omitempty"`
+
+	// open is set while the following lines can still belong to the event
+	open bool
+}
+
+func (e *logEvent) addStack(line string) {
+	if len(e.Stack) < maxEventStack {
+		e.Stack = append(e.Stack, line)
+	}
+}
+
+// isCrash tells crashes and ANRs apart from tagged lines
+func (e *logEvent) isCrash() bool {
+	return e.Kind != eventTag
+}
+
+// summary is a one line description of the event
+func (e *logEvent) summary() string {
+
+	parts := []string{e.Kind}
+	if e.Exception != "" {
+		parts = append(parts, e.Exception)
+	}
+	if e.Message != "" && (e.Exception == "" || e.Kind == eventTag) {
+		parts = append(parts, e.Message)
+	}
+	if e.Process != "" {
+		parts = append(parts, "in "+e.Process)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// 10-18 11:46:11.123  1234  1250 E AndroidRuntime: FATAL EXCEPTION: main
+var logcatLine = regexp.MustCompile(`^(\d\d-\d\d \d\d:\d\d:\d\d\.\d+)\s+(\d+)\s+(\d+)\s+([VDIWEFA])\s+(.*?)\s*: ?(.*)$`)
+
+// Oct 18 11:46:11 iPhone MyApp(UIKitCore)[1234] <Error>: message
+var syslogLine = regexp.MustCompile(`^(\w{3}\s+\d+ \d\d:\d\d:\d\d) (\S+) ([^\[\(]+?)(?:\(([^)]*)\))?\[(\d+)\](?: <(\w+)>)?: (.*)$`)
+
+var (
+	logcatProcess      = regexp.MustCompile(`^Process: ([^,]+), PID: (\d+)`)
+	logcatANR          = regexp.MustCompile(`^ANR in (\S+)`)
+	logcatANRPid       = regexp.MustCompile(`^PID: (\d+)`)
+	logcatNativeHeader = regexp.MustCompile(`pid: (\d+), tid: \d+, name: .*>>> (\S+) <<<`)
+	logcatSignal       = regexp.MustCompile(`^signal (\d+ \(\w+\).*)`)
+	logcatAbort        = regexp.MustCompile(`^Abort message: '(.*)'`)
+	logcatFrame        = regexp.MustCompile(`^\s*#\d+ pc `)
+
+	syslogUncaught = regexp.MustCompile(`Terminating app due to uncaught exception '([^']+)', reason: '(.*)'`)
+	syslogFatal    = regexp.MustCompile(`^(?:\S+/)?\S+:\d+: Fatal error: (.*)|^Fatal error: (.*)`)
+	syslogCrashed  = regexp.MustCompile(`(?:Application|Process) '?([^' \[]+)'?.*(?:crashed|exited abnormally with signal (\d+))`)
+	syslogWatchdog = regexp.MustCompile(`(?i)8badf00d|watchdog`)
+	syslogFrame    = regexp.MustCompile(`^\d+\s+\S+\s+0x[0-9a-f]+ |^\(0x[0-9a-f]+`)
+)
+
+// A deviceLogParser turns the lines of a logcat (threadtime) or iOS syslog into events
+type deviceLogParser struct {
+	tags   map[string]bool
+	events []*logEvent
+
+	// open events by process and tag, lines of other processes are interleaved
+	current map[string]*logEvent
+	line    int
+}
+
+func newDeviceLogParser(tags []string) *deviceLogParser {
+
+	p := &deviceLogParser{
+		tags:    map[string]bool{},
+		current: map[string]*logEvent{},
+	}
+	for _, tag := range splitList(tags) {
+		p.tags[tag] = true
+	}
+
+	return p
+}
+
+func (p *deviceLogParser) start(key string, event *logEvent) {
+	if previous, found := p.current[key]; found {
+		previous.open = false
+	}
+	event.Line = p.line
+	event.open = true
+	p.current[key] = event
+	p.events = append(p.events, event)
+}
+
+// continued gives the open event of a process and tag
+func (p *deviceLogParser) continued(key string) *logEvent {
+	if event, found := p.current[key]; found && event.open {
+		return event
+	}
+	return nil
+}
+
+func (p *deviceLogParser) close(key string) {
+	if event, found := p.current[key]; found {
+		event.open = false
+		delete(p.current, key)
+	}
+}
+
+func (p *deviceLogParser) parseLine(line string) {
+
+	p.line++
+	line = strings.TrimRight(line, "\r\n")
+
+	if m := logcatLine.FindStringSubmatch(line); m != nil {
+		pid, _ := strconv.Atoi(m[2])
+		p.parseLogcat(m[1], pid, m[4], m[5], m[6])
+		return
+	}
+
+	if m := syslogLine.FindStringSubmatch(line); m != nil {
+		pid, _ := strconv.Atoi(m[5])
+		p.parseSyslog(m[1], strings.TrimSpace(m[3]), m[4], pid, m[6], m[7])
+	}
+}
+
+func (p *deviceLogParser) parseLogcat(time string, pid int, level string, tag string, message string) {
+
+	key := fmt.Sprintf("%d/%s", pid, tag)
+
+	switch tag {
+	case "AndroidRuntime":
+		if strings.HasPrefix(message, "FATAL EXCEPTION") {
+			p.start(key, &logEvent{Kind: eventFatalException, Time: time, Pid: pid, Tag: tag, Level: level, Message: message})
+			return
+		}
+		event := p.continued(key)
+		if event == nil {
+			break
+		}
+		if m := logcatProcess.FindStringSubmatch(message); m != nil {
+			event.Process = m[1]
+			event.Pid, _ = strconv.Atoi(m[2])
+			return
+		}
+		// The first line after the process is the exception, the rest is the stack
+		if event.Exception == "" && !strings.HasPrefix(strings.TrimSpace(message), "at ") {
+			event.Exception, event.Message = splitException(message)
+			return
+		}
+		event.addStack(strings.TrimSpace(message))
+		return
+
+	case "ActivityManager":
+		if m := logcatANR.FindStringSubmatch(message); m != nil {
+			p.start(key, &logEvent{Kind: eventANR, Time: time, Process: m[1], Tag: tag, Level: level, Message: message})
+			return
+		}
+		event := p.continued(key)
+		if event == nil {
+			break
+		}
+		switch {
+		case logcatANRPid.MatchString(message):
+			event.Pid, _ = strconv.Atoi(logcatANRPid.FindStringSubmatch(message)[1])
+		case strings.HasPrefix(message, "Reason: "):
+			event.Message = strings.TrimPrefix(message, "Reason: ")
+		case strings.HasPrefix(message, "Parent: "), strings.HasPrefix(message, "Frozen: "),
+			strings.HasPrefix(message, "ErrorId: "), strings.HasPrefix(message, "Frontend"),
+			strings.HasPrefix(message, "Load: "), strings.HasPrefix(message, "CPU usage"),
+			strings.HasPrefix(message, "  "):
+			event.addStack(strings.TrimSpace(message))
+		default:
+			p.close(key)
+		}
+		return
+
+	case "DEBUG":
+		if strings.HasPrefix(message, "*** *** ***") {
+			p.start(key, &logEvent{Kind: eventNativeCrash, Time: time, Tag: tag, Level: level})
+			return
+		}
+		event := p.continued(key)
+		if event == nil {
+			break
+		}
+		message = strings.TrimSpace(message)
+		switch {
+		case logcatNativeHeader.MatchString(message):
+			m := logcatNativeHeader.FindStringSubmatch(message)
+			event.Pid, _ = strconv.Atoi(m[1])
+			event.Process = m[2]
+		case logcatSignal.MatchString(message):
+			event.Exception = "signal " + logcatSignal.FindStringSubmatch(message)[1]
+		case logcatAbort.MatchString(message):
+			event.Message = logcatAbort.FindStringSubmatch(message)[1]
+		case logcatFrame.MatchString(message):
+			event.addStack(message)
+		}
+		return
+	}
+
+	if p.tags[tag] {
+		p.start(key, &logEvent{Kind: eventTag, Time: time, Pid: pid, Tag: tag, Level: level, Message: message})
+		p.close(key)
+	}
+}
+
+func (p *deviceLogParser) parseSyslog(time string, process string, library string, pid int, level string, message string) {
+
+	key := fmt.Sprintf("%d/%s", pid, process)
+
+	if m := syslogUncaught.FindStringSubmatch(message); m != nil {
+		p.start(key, &logEvent{Kind: eventUncaughtException, Time: time, Process: process, Pid: pid, Tag: library, Level: level, Exception: m[1], Message: m[2]})
+		return
+	}
+
+	if m := syslogFatal.FindStringSubmatch(message); m != nil {
+		text := m[1] + m[2]
+		p.start(key, &logEvent{Kind: eventCrash, Time: time, Process: process, Pid: pid, Tag: library, Level: level, Exception: "Fatal error", Message: text})
+		return
+	}
+
+	// The stack of an uncaught exception follows on the lines of the same process
+	if event := p.continued(key); event != nil {
+		if strings.HasPrefix(message, "*** First throw call stack") {
+			return
+		}
+		if syslogFrame.MatchString(strings.TrimSpace(message)) {
+			event.addStack(strings.TrimSpace(message))
+			return
+		}
+		p.close(key)
+	}
+
+	// Crashes of apps are reported by the system (ReportCrash, SpringBoard, ...)
+	if process != "ReportCrash" && process != "SpringBoard" && process != "osanalyticshelper" && process != "backboardd" {
+		if p.tags[process] || p.tags[library] {
+			p.start(key, &logEvent{Kind: eventTag, Time: time, Process: process, Pid: pid, Tag: library, Level: level, Message: message})
+			p.close(key)
+		}
+		return
+	}
+
+	if syslogWatchdog.MatchString(message) {
+		p.start(key, &logEvent{Kind: eventWatchdog, Time: time, Tag: process, Level: level, Message: message})
+		p.close(key)
+		return
+	}
+
+	if m := syslogCrashed.FindStringSubmatch(message); m != nil {
+		app := strings.TrimPrefix(m[1], "UIKitApplication:")
+		event := &logEvent{Kind: eventCrash, Time: time, Process: app, Tag: process, Level: level, Message: message}
+		if m[2] != "" {
+			event.Exception = "signal " + m[2]
+		}
+		p.start(key, event)
+		p.close(key)
+	}
+}
+
+// splitException splits "java.lang.IllegalStateException: message" in the type and the message
+func splitException(line string) (string, string) {
+
+	line = strings.TrimSpace(line)
+	if i := strings.Index(line, ": "); i > 0 && !strings.Contains(line[:i], " ") {
+		return line[:i], line[i+2:]
+	}
+
+	return line, ""
+}
+
+/* Parse an Android logcat or iOS syslog for crashes, ANRs and tagged lines */
+func parseDeviceLog(r io.Reader, tags []string) ([]*logEvent, error) {
+
+	p := newDeviceLogParser(tags)
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			p.parseLine(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p.events, nil
+}
+
+func parseDeviceLogFile(fileName string, tags []string) ([]*logEvent, error) {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events, err := parseDeviceLog(file, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		event.Log = fileName
+	}
+
+	return events, nil
+}
+
+// isDeviceLog tells if an artifact is a device log we know how to parse
+func isDeviceLog(artifact *artifactNode) bool {
+	return aws.StringValue(artifact.Artifact.Type) == "DEVICE_LOG" && artifact.Path != ""
+}
+
+/* Parse the downloaded device logs of every job of a run */
+func analyzeDeviceLogs(tree *runTree, tags []string) error {
+
+	for _, job := range tree.Jobs {
+		job.Events = nil
+
+		for _, artifact := range job.Artifacts {
+			if !isDeviceLog(artifact) {
+				continue
+			}
+			events, err := parseDeviceLogFile(artifact.Path, tags)
+			if err != nil {
+				return err
+			}
+			job.Events = append(job.Events, events...)
+		}
+
+		for _, suite := range job.Suites {
+			for _, artifact := range suite.Artifacts {
+				if !isDeviceLog(artifact) {
+					continue
+				}
+				events, err := parseDeviceLogFile(artifact.Path, tags)
+				if err != nil {
+					return err
+				}
+				for _, event := range events {
+					event.Suite = aws.StringValue(suite.Suite.Name)
+				}
+				job.Events = append(job.Events, events...)
+			}
+		}
+	}
+
+	return nil
+}
+
+// crashCount counts the crashes and ANRs of a job
+func crashCount(job *jobNode) int {
+	count := 0
+	for _, event := range job.Events {
+		if event.isCrash() {
+			count++
+		}
+	}
+	return count
+}
+
+// A logEventsDocument is the JSON export of the events, per device
+type logEventsDocument struct {
+	Run     string         `json:"run"`
+	Crashes []*crashGroup  `json:"crashes"`
+	Jobs    []logEventsJob `json:"jobs"`
+}
+
+type logEventsJob struct {
+	Job    string      `json:"job"`
+	Arn    string      `json:"arn"`
+	Device string      `json:"device"`
+	Os     string      `json:"os"`
+	Events []*logEvent `json:"events"`
+}
+
+/* Write the events found in the device logs as JSON */
+func writeLogEvents(tree *runTree, fileName string) error {
+
+	doc := logEventsDocument{
+		Run:     aws.StringValue(tree.Run.Arn),
+		Crashes: tree.Crashes,
+		Jobs:    []logEventsJob{},
+	}
+	if doc.Crashes == nil {
+		doc.Crashes = []*crashGroup{}
+	}
+
+	for _, job := range tree.Jobs {
+		j := logEventsJob{
+			Job:    aws.StringValue(job.Job.Name),
+			Arn:    aws.StringValue(job.Job.Arn),
+			Events: job.Events,
+		}
+		if job.Job.Device != nil {
+			j.Device = deviceFriendlyName(job.Job.Device)
+			j.Os = aws.StringValue(job.Job.Device.Os)
+		}
+		if j.Events == nil {
+			j.Events = []*logEvent{}
+		}
+		doc.Jobs = append(doc.Jobs, j)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, data, 0666)
+}
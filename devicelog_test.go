@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const fatalExceptionLog = `10-18 11:46:10.001  1234  1234 I MyApp   : starting
+10-18 11:46:11.123  1234  1234 E AndroidRuntime: FATAL EXCEPTION: main
+10-18 11:46:11.123  1234  1234 E AndroidRuntime: Process: com.example.app, PID: 1234
+10-18 11:46:11.123  1234  1234 E AndroidRuntime: java.lang.IllegalStateException: Fragment not attached
+10-18 11:46:11.123  5678  5678 D Other   : interleaved
+10-18 11:46:11.123  1234  1234 E AndroidRuntime: 	at com.example.app.Home.onClick(Home.java:42)
+10-18 11:46:11.123  1234  1234 E AndroidRuntime: 	at android.view.View.performClick(View.java:7448)
+`
+
+const anrLog = `10-18 11:50:00.000   900  1000 E ActivityManager: ANR in com.example.app (com.example.app/.Home)
+10-18 11:50:00.000   900  1000 E ActivityManager: PID: 4321
+10-18 11:50:00.000   900  1000 E ActivityManager: Reason: Input dispatching timed out
+10-18 11:50:00.000   900  1000 E ActivityManager: Load: 1.0 / 0.5 / 0.2
+10-18 11:50:00.000   900  1000 E ActivityManager: Done dumping
+`
+
+const nativeCrashLog = `10-18 12:00:00.000  2000  2000 F DEBUG   : *** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***
+10-18 12:00:00.000  2000  2000 F DEBUG   : pid: 3333, tid: 3333, name: example.app  >>> com.example.app <<<
+10-18 12:00:00.000  2000  2000 F DEBUG   : signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0
+10-18 12:00:00.000  2000  2000 F DEBUG   : Abort message: 'null pointer'
+10-18 12:00:00.000  2000  2000 F DEBUG   :     #00 pc 0000000000012345  /data/app/lib/arm64/libnative.so (crash+12)
+10-18 12:00:00.000  2000  2000 F DEBUG   :     #01 pc 0000000000012399  /data/app/lib/arm64/libnative.so (main+40)
+`
+
+const syslogLog = `Oct 18 11:46:11 iPhone MyApp(CoreFoundation)[1234] <Notice>: *** Terminating app due to uncaught exception 'NSInvalidArgumentException', reason: 'unrecognized selector'
+Oct 18 11:46:11 iPhone MyApp(CoreFoundation)[1234] <Notice>: *** First throw call stack:
+Oct 18 11:46:11 iPhone MyApp(CoreFoundation)[1234] <Notice>: (0x1a2b3c4d 0x1a2b3c5e)
+Oct 18 11:46:11 iPhone MyApp[1234] <Notice>: next line
+Oct 18 11:46:12 iPhone ReportCrash(CrashReporterSupport)[99] <Notice>: Process MyApp [1234] crashed
+Oct 18 11:46:13 iPhone SpringBoard(FrontBoard)[50] <Error>: MyApp watchdog transgression: exhausted real (wall clock) time allowance of 19.91 seconds 8badf00d
+Oct 18 11:46:14 iPhone Other(Swift)[77] <Error>: Main.swift:12: Fatal error: Unexpectedly found nil
+`
+
+const taggedLog = `10-18 11:46:10.001  1234  1234 I Checkout: paid
+10-18 11:46:10.002  1234  1234 I Cart    : added
+Oct 18 11:46:11 iPhone MyApp(Checkout)[1234] <Notice>: paid on iOS
+`
+
+func TestParseDeviceLog(t *testing.T) {
+
+	type wantEvent struct {
+		kind      string
+		process   string
+		pid       int
+		exception string
+		message   string
+		stack     int
+		line      int
+	}
+
+	tests := []struct {
+		name string
+		log  string
+		tags []string
+		want []wantEvent
+	}{
+		{"fatal exception", fatalExceptionLog, nil, []wantEvent{
+			{eventFatalException, "com.example.app", 1234, "java.lang.IllegalStateException", "Fragment not attached", 2, 2},
+		}},
+		{"anr", anrLog, nil, []wantEvent{
+			{eventANR, "com.example.app", 4321, "", "Input dispatching timed out", 1, 1},
+		}},
+		{"native crash", nativeCrashLog, nil, []wantEvent{
+			{eventNativeCrash, "com.example.app", 3333, "signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0", "null pointer", 2, 1},
+		}},
+		{"ios", syslogLog, nil, []wantEvent{
+			{eventUncaughtException, "MyApp", 1234, "NSInvalidArgumentException", "unrecognized selector", 1, 1},
+			{eventCrash, "MyApp", 0, "", "Process MyApp [1234] crashed", 0, 5},
+			{eventWatchdog, "", 0, "", "MyApp watchdog transgression: exhausted real (wall clock) time allowance of 19.91 seconds 8badf00d", 0, 6},
+			{eventCrash, "Other", 77, "Fatal error", "Unexpectedly found nil", 0, 7},
+		}},
+		{"tags", taggedLog, []string{"Checkout"}, []wantEvent{
+			{eventTag, "", 1234, "", "paid", 0, 1},
+			{eventTag, "MyApp", 1234, "", "paid on iOS", 0, 3},
+		}},
+		{"no tags", taggedLog, nil, []wantEvent{}},
+		{"windows line endings", strings.Replace(fatalExceptionLog, "\n", "\r\n", -1), nil, []wantEvent{
+			{eventFatalException, "com.example.app", 1234, "java.lang.IllegalStateException", "Fragment not attached", 2, 2},
+		}},
+	}
+
+	for _, test := range tests {
+		events, err := parseDeviceLog(strings.NewReader(test.log), test.tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(test.want) {
+			t.Errorf("%s: got %d events, want %d", test.name, len(events), len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			e := events[i]
+			got := wantEvent{e.Kind, e.Process, e.Pid, e.Exception, e.Message, len(e.Stack), e.Line}
+			if got != want {
+				t.Errorf("%s: event %d is %+v, want %+v", test.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestSplitException(t *testing.T) {
+
+	tests := []struct {
+		line      string
+		exception string
+		message   string
+	}{
+		{"java.lang.IllegalStateException: Fragment not attached", "java.lang.IllegalStateException", "Fragment not attached"},
+		{"  java.lang.NullPointerException  ", "java.lang.NullPointerException", ""},
+		{"Caused by: something: else", "Caused by: something: else", ""},
+		{"kotlin.KotlinNullPointerException: a: b", "kotlin.KotlinNullPointerException", "a: b"},
+	}
+
+	for _, test := range tests {
+		exception, message := splitException(test.line)
+		if exception != test.exception || message != test.message {
+			t.Errorf("splitException(%q) = %q, %q, want %q, %q", test.line, exception, message, test.exception, test.message)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"github.com/olekukonko/tablewriter"
+	"strings"
+)
+
+/* Get the compatibility of a device pool with an app and test type */
+func devicePoolCompatibility(svc *devicefarm.DeviceFarm, devicePoolArn string, appArn string, testType string) (*devicefarm.GetDevicePoolCompatibilityOutput, error) {
+
+	compatReq := &devicefarm.GetDevicePoolCompatibilityInput{
+		DevicePoolArn: aws.String(devicePoolArn),
+	}
+
+	if appArn != "" {
+		compatReq.AppArn = aws.String(appArn)
+	}
+
+	if testType != "" {
+		compatReq.TestType = aws.String(testType)
+	}
+
+	return svc.GetDevicePoolCompatibility(compatReq)
+}
+
+/* Check a device pool and print the compatible and incompatible devices */
+func checkDevicePool(svc *devicefarm.DeviceFarm, devicePoolArn string, appArn string, testType string, warnIncompatible bool) error {
+
+	if devicePoolArn == "" {
+		return errors.New("we need a devicepool to check")
+	}
+
+	resp, err := devicePoolCompatibility(svc, devicePoolArn, appArn, testType)
+	if err != nil {
+		return err
+	}
+
+	printDevicePoolCompatibility(resp)
+
+	if len(resp.CompatibleDevices) == 0 {
+		if !warnIncompatible {
+			return errors.New("none of the devices in the devicepool are compatible with the app and test type")
+		}
+		fmt.Fprintln(progress, "- Warning: none of the devices in the devicepool are compatible with the app and test type")
+	}
+
+	return nil
+}
+
+func printDevicePoolCompatibility(resp *devicefarm.GetDevicePoolCompatibilityOutput) {
+
+	table := tablewriter.NewWriter(progress)
+	table.SetHeader([]string{"Name", "Os", "Platform", "Compatible", "Reason"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(80)
+
+	results := append([]*devicefarm.DevicePoolCompatibilityResult{}, resp.CompatibleDevices...)
+	results = append(results, resp.IncompatibleDevices...)
+	for _, m := range results {
+		compatible := "no"
+		if aws.BoolValue(m.Compatible) {
+			compatible = "yes"
+		}
+
+		reasons := []string{}
+		for _, msg := range m.IncompatibilityMessages {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", aws.StringValue(msg.Type), aws.StringValue(msg.Message)))
+		}
+
+		device := m.Device
+		if device == nil {
+			device = &devicefarm.Device{}
+		}
+
+		line := []string{aws.StringValue(device.Name), aws.StringValue(device.Os), aws.StringValue(device.Platform), compatible, strings.Join(reasons, "; ")}
+		table.Append(line)
+	}
+	table.Render() // Send output
+
+	fmt.Fprintf(progress, "- %d compatible, %d incompatible devices\n", len(resp.CompatibleDevices), len(resp.IncompatibleDevices))
+}
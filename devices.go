@@ -0,0 +1,568 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A deviceQuery describes the device(s) a user is looking for, e.g. "Galaxy S21@>=12"
+type deviceQuery struct {
+	Text     string
+	Os       []versionConstraint
+	Platform string
+}
+
+// A versionConstraint is a single comparison against an OS version, e.g. ">=12"
+type versionConstraint struct {
+	Op      string
+	Version string
+}
+
+// A deviceCandidate is a device that matches a query, with how well it matches
+type deviceCandidate struct {
+	Device *devicefarm.Device
+	Score  int
+}
+
+/* List all devices, following the pagination */
+func listAllDevices(svc *devicefarm.DeviceFarm) ([]*devicefarm.Device, error) {
+
+	devices := []*devicefarm.Device{}
+	input := &devicefarm.ListDevicesInput{}
+	err := svc.ListDevicesPages(input, func(page *devicefarm.ListDevicesOutput, lastPage bool) bool {
+		devices = append(devices, page.Devices...)
+		return true
+	})
+
+	return devices, err
+}
+
+func deviceFriendlyName(device *devicefarm.Device) string {
+	return fmt.Sprintf("%s - %s", aws.StringValue(device.Name), aws.StringValue(device.Os))
+}
+
+func isArn(value string) bool {
+	return strings.HasPrefix(value, "arn:aws:devicefarm:")
+}
+
+// parseDeviceQuery splits "<text>@<os constraints>" into a query,
+// the constraints are comma separated: "Pixel 4@>=10,<12"
+func parseDeviceQuery(query string, platform string) (deviceQuery, error) {
+
+	q := deviceQuery{
+		Text:     strings.TrimSpace(query),
+		Platform: strings.ToUpper(strings.TrimSpace(platform)),
+	}
+
+	if at := strings.LastIndex(query, "@"); at >= 0 {
+		q.Text = strings.TrimSpace(query[:at])
+		constraints, err := parseVersionConstraints(query[at+1:])
+		if err != nil {
+			return q, err
+		}
+		q.Os = constraints
+	}
+
+	return q, nil
+}
+
+func parseVersionConstraints(value string) ([]versionConstraint, error) {
+
+	constraints := []versionConstraint{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		op := "="
+		for _, candidate := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				part = strings.TrimSpace(part[len(candidate):])
+				break
+			}
+		}
+		if op == "==" {
+			op = "="
+		}
+
+		if part == "" {
+			return nil, errors.New("missing version in os constraint " + value)
+		}
+		constraints = append(constraints, versionConstraint{Op: op, Version: part})
+	}
+
+	return constraints, nil
+}
+
+// compareVersions compares dotted versions numerically, "10" < "9.1" is false
+func compareVersions(a string, b string) int {
+
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+
+		aNum, aErr := strconv.Atoi(aPart)
+		bNum, bErr := strconv.Atoi(bPart)
+		if aErr == nil && bErr == nil {
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+
+		if c := strings.Compare(aPart, bPart); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func (c versionConstraint) matches(version string) bool {
+
+	switch c.Op {
+	case ">=":
+		return compareVersions(version, c.Version) >= 0
+	case "<=":
+		return compareVersions(version, c.Version) <= 0
+	case ">":
+		return compareVersions(version, c.Version) > 0
+	case "<":
+		return compareVersions(version, c.Version) < 0
+	case "!=":
+		return !versionHasPrefix(version, c.Version)
+	}
+
+	// A plain version matches all versions it is a prefix of: "12" matches "12.0.1"
+	return versionHasPrefix(version, c.Version)
+}
+
+func versionHasPrefix(version string, prefix string) bool {
+
+	versionParts := strings.Split(version, ".")
+	prefixParts := strings.Split(prefix, ".")
+	if len(prefixParts) > len(versionParts) {
+		return compareVersions(version, prefix) == 0
+	}
+
+	return compareVersions(strings.Join(versionParts[:len(prefixParts)], "."), prefix) == 0
+}
+
+func (q deviceQuery) matchesOs(version string) bool {
+
+	for _, constraint := range q.Os {
+		if !constraint.matches(version) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeDeviceText(value string) string {
+	return strings.Join(strings.Fields(strings.ToLower(value)), " ")
+}
+
+// scoreDevice returns how well a device matches the query text, 0 means no match
+func scoreDevice(device *devicefarm.Device, text string) int {
+
+	query := normalizeDeviceText(text)
+	if query == "" {
+		return 1
+	}
+
+	name := normalizeDeviceText(aws.StringValue(device.Name))
+	model := normalizeDeviceText(aws.StringValue(device.Model))
+	manufacturer := normalizeDeviceText(aws.StringValue(device.Manufacturer))
+
+	switch query {
+	case normalizeDeviceText(deviceFriendlyName(device)):
+		return 100
+	case name:
+		return 90
+	case model, normalizeDeviceText(aws.StringValue(device.ModelId)), strings.TrimSpace(manufacturer + " " + model):
+		return 80
+	}
+
+	// The name must start with the whole query words: "Galaxy S2" is not "Galaxy S21"
+	if strings.HasPrefix(name, query+" ") {
+		return 70
+	}
+
+	haystack := strings.Join([]string{name, model, manufacturer, normalizeDeviceText(aws.StringValue(device.ModelId))}, " ")
+	words := map[string]bool{}
+	for _, word := range strings.Fields(haystack) {
+		words[strings.Trim(word, "()")] = true
+	}
+
+	queryWords := strings.Fields(query)
+	wordMatch := true
+	for _, word := range queryWords {
+		if !words[word] {
+			wordMatch = false
+			break
+		}
+	}
+
+	if !wordMatch {
+		return 0
+	}
+
+	// Prefer the device with the least extra words: "Pixel 4" before "Pixel 4 XL"
+	score := 60 - (len(strings.Fields(name)) - len(queryWords))
+	if score < 51 {
+		score = 51
+	}
+	return score
+}
+
+/* Find and rank the devices that match a query */
+func matchDevices(devices []*devicefarm.Device, q deviceQuery) []deviceCandidate {
+
+	candidates := []deviceCandidate{}
+	for _, device := range devices {
+		if q.Platform != "" && aws.StringValue(device.Platform) != q.Platform {
+			continue
+		}
+
+		if isArn(q.Text) {
+			if aws.StringValue(device.Arn) == q.Text {
+				candidates = append(candidates, deviceCandidate{Device: device, Score: 100})
+			}
+			continue
+		}
+
+		if !q.matchesOs(aws.StringValue(device.Os)) {
+			continue
+		}
+
+		score := scoreDevice(device, q.Text)
+		if score > 0 {
+			candidates = append(candidates, deviceCandidate{Device: device, Score: score})
+		}
+	}
+
+	// Best score first, then the most recent OS
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if c := compareVersions(aws.StringValue(candidates[i].Device.Os), aws.StringValue(candidates[j].Device.Os)); c != 0 {
+			return c > 0
+		}
+		return aws.StringValue(candidates[i].Device.Name) < aws.StringValue(candidates[j].Device.Name)
+	})
+
+	return candidates
+}
+
+/* Suggest the devices closest to a query that did not match */
+func suggestDevices(devices []*devicefarm.Device, q deviceQuery, max int) []string {
+
+	query := normalizeDeviceText(q.Text)
+	type suggestion struct {
+		name     string
+		distance int
+	}
+
+	seen := map[string]bool{}
+	suggestions := []suggestion{}
+	for _, device := range devices {
+		if q.Platform != "" && aws.StringValue(device.Platform) != q.Platform {
+			continue
+		}
+
+		name := deviceFriendlyName(device)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		distance := levenshtein(query, normalizeDeviceText(aws.StringValue(device.Name)))
+		suggestions = append(suggestions, suggestion{name: name, distance: distance})
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].name < suggestions[j].name
+	})
+
+	names := []string{}
+	for i := 0; i < len(suggestions) && i < max; i++ {
+		names = append(names, suggestions[i].name)
+	}
+
+	return names
+}
+
+func levenshtein(a string, b string) int {
+
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(br)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// A deviceFilter narrows down the device catalog, empty fields match everything
+type deviceFilter struct {
+	Name         string
+	Platform     string
+	FormFactor   string
+	Os           []versionConstraint
+	Manufacturer string
+	Availability []string
+	FleetType    string
+	RemoteAccess *bool
+	RemoteDebug  *bool
+}
+
+func (f deviceFilter) matches(device *devicefarm.Device) bool {
+
+	if f.Name != "" && scoreDevice(device, f.Name) == 0 {
+		return false
+	}
+
+	if f.Platform != "" && !strings.EqualFold(aws.StringValue(device.Platform), f.Platform) {
+		return false
+	}
+
+	if f.FormFactor != "" && !strings.EqualFold(aws.StringValue(device.FormFactor), f.FormFactor) {
+		return false
+	}
+
+	if !(deviceQuery{Os: f.Os}).matchesOs(aws.StringValue(device.Os)) {
+		return false
+	}
+
+	if f.Manufacturer != "" && !strings.EqualFold(aws.StringValue(device.Manufacturer), f.Manufacturer) {
+		return false
+	}
+
+	if len(f.Availability) > 0 {
+		found := false
+		for _, availability := range f.Availability {
+			if strings.EqualFold(aws.StringValue(device.Availability), availability) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.FleetType != "" && !strings.EqualFold(aws.StringValue(device.FleetType), f.FleetType) {
+		return false
+	}
+
+	if f.RemoteAccess != nil && aws.BoolValue(device.RemoteAccessEnabled) != *f.RemoteAccess {
+		return false
+	}
+
+	if f.RemoteDebug != nil && aws.BoolValue(device.RemoteDebugEnabled) != *f.RemoteDebug {
+		return false
+	}
+
+	return true
+}
+
+// A deviceColumn is a column that can be shown and sorted on when listing devices
+type deviceColumn struct {
+	Header  string
+	Value   func(device *devicefarm.Device) string
+	Compare func(a *devicefarm.Device, b *devicefarm.Device) int
+}
+
+var defaultDeviceColumns = []string{"name", "os", "platform", "form", "arn"}
+
+var deviceColumns = map[string]deviceColumn{
+	"name": {Header: "Name", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Name) }},
+	"os": {Header: "Os", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Os) }, Compare: func(a *devicefarm.Device, b *devicefarm.Device) int {
+		return compareVersions(aws.StringValue(a.Os), aws.StringValue(b.Os))
+	}},
+	"platform":     {Header: "Platform", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Platform) }},
+	"form":         {Header: "Form", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.FormFactor) }},
+	"arn":          {Header: "Arn", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Arn) }},
+	"manufacturer": {Header: "Manufacturer", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Manufacturer) }},
+	"model":        {Header: "Model", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Model) }},
+	"model-id":     {Header: "Model Id", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.ModelId) }},
+	"availability": {Header: "Availability", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Availability) }},
+	"cpu": {Header: "Cpu", Value: deviceCPU, Compare: func(a *devicefarm.Device, b *devicefarm.Device) int {
+		return compareFloats(deviceClock(a), deviceClock(b))
+	}},
+	"memory": {Header: "Memory", Value: func(d *devicefarm.Device) string { return formatBytes(aws.Int64Value(d.Memory)) }, Compare: func(a *devicefarm.Device, b *devicefarm.Device) int {
+		return compareFloats(float64(aws.Int64Value(a.Memory)), float64(aws.Int64Value(b.Memory)))
+	}},
+	"heap": {Header: "Heap", Value: func(d *devicefarm.Device) string { return formatBytes(aws.Int64Value(d.HeapSize)) }, Compare: func(a *devicefarm.Device, b *devicefarm.Device) int {
+		return compareFloats(float64(aws.Int64Value(a.HeapSize)), float64(aws.Int64Value(b.HeapSize)))
+	}},
+	"resolution": {Header: "Resolution", Value: deviceResolution, Compare: func(a *devicefarm.Device, b *devicefarm.Device) int {
+		return compareFloats(devicePixels(a), devicePixels(b))
+	}},
+	"fleet":         {Header: "Fleet", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.FleetName) }},
+	"fleet-type":    {Header: "Fleet Type", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.FleetType) }},
+	"remote-access": {Header: "Remote Access", Value: func(d *devicefarm.Device) string { return strconv.FormatBool(aws.BoolValue(d.RemoteAccessEnabled)) }},
+	"remote-debug":  {Header: "Remote Debug", Value: func(d *devicefarm.Device) string { return strconv.FormatBool(aws.BoolValue(d.RemoteDebugEnabled)) }},
+	"carrier":       {Header: "Carrier", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Carrier) }},
+	"radio":         {Header: "Radio", Value: func(d *devicefarm.Device) string { return aws.StringValue(d.Radio) }},
+	"instances":     {Header: "Instances", Value: func(d *devicefarm.Device) string { return strconv.Itoa(len(d.Instances)) }, Compare: func(a *devicefarm.Device, b *devicefarm.Device) int { return len(a.Instances) - len(b.Instances) }},
+}
+
+func deviceColumnNames() []string {
+	names := []string{}
+	for name := range deviceColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupDeviceColumns(names []string) ([]deviceColumn, error) {
+
+	columns := []deviceColumn{}
+	for _, name := range names {
+		column, ok := deviceColumns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown device column %s, use one of [%s]", name, strings.Join(deviceColumnNames(), ","))
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// sortDevices sorts on a list of columns, a "-" prefix sorts descending: "platform,-os"
+func sortDevices(devices []*devicefarm.Device, sortBy []string) error {
+
+	type sortKey struct {
+		column     deviceColumn
+		descending bool
+	}
+
+	keys := []sortKey{}
+	for _, name := range sortBy {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		descending := strings.HasPrefix(name, "-")
+		columns, err := lookupDeviceColumns([]string{strings.TrimPrefix(name, "-")})
+		if err != nil {
+			return err
+		}
+		keys = append(keys, sortKey{column: columns[0], descending: descending})
+	}
+
+	sort.SliceStable(devices, func(i, j int) bool {
+		for _, key := range keys {
+			var c int
+			if key.column.Compare != nil {
+				c = key.column.Compare(devices[i], devices[j])
+			} else {
+				c = strings.Compare(strings.ToLower(key.column.Value(devices[i])), strings.ToLower(key.column.Value(devices[j])))
+			}
+			if c != 0 {
+				return (c < 0) != key.descending
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+func deviceCPU(device *devicefarm.Device) string {
+	if device.Cpu == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %v%s", aws.StringValue(device.Cpu.Architecture), aws.Float64Value(device.Cpu.Clock), aws.StringValue(device.Cpu.Frequency)))
+}
+
+func deviceClock(device *devicefarm.Device) float64 {
+	if device.Cpu == nil {
+		return 0
+	}
+	return aws.Float64Value(device.Cpu.Clock)
+}
+
+func deviceResolution(device *devicefarm.Device) string {
+	if device.Resolution == nil {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", aws.Int64Value(device.Resolution.Width), aws.Int64Value(device.Resolution.Height))
+}
+
+func devicePixels(device *devicefarm.Device) float64 {
+	if device.Resolution == nil {
+		return 0
+	}
+	return float64(aws.Int64Value(device.Resolution.Width) * aws.Int64Value(device.Resolution.Height))
+}
+
+func formatBytes(size int64) string {
+	if size <= 0 {
+		return ""
+	}
+
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + " " + units[unit]
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
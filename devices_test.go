@@ -0,0 +1,177 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"10", "9.1", 1},
+		{"9.1", "10", -1},
+		{"4.2.2", "4.2.2", 0},
+		{"4.2", "4.2.0", 0},
+		{"4.2.1", "4.2", 1},
+		{"13.4.1", "13.10", -1},
+		{"12", "12.0.1", -1},
+		{"11.a", "11.b", -1},
+	}
+
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"", "", 0},
+		{"", "pixel", 5},
+		{"pixel", "", 5},
+		{"pixel", "pixel", 0},
+		{"pixel", "pixl", 1},
+		{"galaxy", "galxay", 2},
+		{"kitten", "sitting", 3},
+		{"nexus", "nexüs", 1},
+	}
+
+	for _, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestParseDeviceQuery(t *testing.T) {
+
+	tests := []struct {
+		query    string
+		platform string
+		text     string
+		os       []versionConstraint
+		err      bool
+	}{
+		{" Pixel 4 ", "android", "Pixel 4", nil, false},
+		{"Galaxy S21@>=12", "", "Galaxy S21", []versionConstraint{{">=", "12"}}, false},
+		{"Pixel 4@>=10, <12", "", "Pixel 4", []versionConstraint{{">=", "10"}, {"<", "12"}}, false},
+		{"iPhone@==14.1", "ios", "iPhone", []versionConstraint{{"=", "14.1"}}, false},
+		{"Nexus@!=6,11", "", "Nexus", []versionConstraint{{"!=", "6"}, {"=", "11"}}, false},
+		{"Pixel@>=", "", "", nil, true},
+	}
+
+	for _, test := range tests {
+		q, err := parseDeviceQuery(test.query, test.platform)
+		if (err != nil) != test.err {
+			t.Errorf("parseDeviceQuery(%q) error is %v, want an error %v", test.query, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if q.Text != test.text || q.Platform != strings.ToUpper(test.platform) || len(q.Os) != len(test.os) {
+			t.Errorf("parseDeviceQuery(%q) = %+v, want %q with %v", test.query, q, test.text, test.os)
+			continue
+		}
+		for i := range q.Os {
+			if q.Os[i] != test.os[i] {
+				t.Errorf("parseDeviceQuery(%q) constraint %d is %v, want %v", test.query, i, q.Os[i], test.os[i])
+			}
+		}
+	}
+}
+
+func testDevice(name string, model string, os string, platform string) *devicefarm.Device {
+	return &devicefarm.Device{
+		Arn:          aws.String("arn:aws:devicefarm:us-west-2::device:" + strings.ToUpper(strings.Replace(name+os, " ", "", -1))),
+		Name:         aws.String(name),
+		Model:        aws.String(model),
+		Manufacturer: aws.String(strings.Fields(name)[0]),
+		Os:           aws.String(os),
+		Platform:     aws.String(platform),
+	}
+}
+
+func TestScoreDevice(t *testing.T) {
+
+	s21 := testDevice("Samsung Galaxy S21", "SM-G991U", "11", "ANDROID")
+	s21Ultra := testDevice("Samsung Galaxy S21 Ultra", "SM-G998U", "11", "ANDROID")
+	pixel := testDevice("Google Pixel 4", "Pixel 4", "10", "ANDROID")
+
+	tests := []struct {
+		device *devicefarm.Device
+		text   string
+		want   int
+	}{
+		{s21, "", 1},
+		{s21, "Samsung Galaxy S21 - 11", 100},
+		{s21, "samsung  galaxy s21", 90},
+		{s21, "SM-G991U", 80},
+		{pixel, "Google Pixel 4", 90},
+		{pixel, "pixel 4", 80},
+		{s21Ultra, "Samsung Galaxy S21", 70},
+		{s21, "Galaxy S21", 59},
+		{s21Ultra, "S21 Ultra", 58},
+		{s21, "Galaxy S2", 0},
+		{s21, "Galaxy", 58},
+		{s21, "Pixel", 0},
+	}
+
+	for _, test := range tests {
+		if got := scoreDevice(test.device, test.text); got != test.want {
+			t.Errorf("scoreDevice(%s, %q) = %d, want %d", aws.StringValue(test.device.Name), test.text, got, test.want)
+		}
+	}
+}
+
+func TestMatchDevices(t *testing.T) {
+
+	devices := []*devicefarm.Device{
+		testDevice("Google Pixel 4", "Pixel 4", "10", "ANDROID"),
+		testDevice("Google Pixel 4", "Pixel 4", "11", "ANDROID"),
+		testDevice("Google Pixel 4 XL", "Pixel 4 XL", "11", "ANDROID"),
+		testDevice("Samsung Galaxy S21", "SM-G991U", "12", "ANDROID"),
+		testDevice("Apple iPhone 11", "iPhone 11", "14.1", "IOS"),
+	}
+
+	tests := []struct {
+		query    string
+		platform string
+		want     []string
+	}{
+		{"Pixel 4", "", []string{"Google Pixel 4 - 11", "Google Pixel 4 - 10", "Google Pixel 4 XL - 11"}},
+		{"Pixel 4@<11", "", []string{"Google Pixel 4 - 10"}},
+		{"Pixel@>=11", "", []string{"Google Pixel 4 - 11", "Google Pixel 4 XL - 11"}},
+		{"11", "", []string{"Apple iPhone 11 - 14.1"}},
+		{"11", "android", []string{}},
+		{"Galaxy S2", "", []string{}},
+		{"arn:aws:devicefarm:us-west-2::device:APPLEIPHONE1114.1", "", []string{"Apple iPhone 11 - 14.1"}},
+		{"arn:aws:devicefarm:us-west-2::device:APPLEIPHONE1114.1", "android", []string{}},
+	}
+
+	for _, test := range tests {
+		q, err := parseDeviceQuery(test.query, test.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, candidate := range matchDevices(devices, q) {
+			got = append(got, deviceFriendlyName(candidate.Device))
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("matchDevices(%q, %q) = %v, want %v", test.query, test.platform, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errURLExpired is returned when the presigned artifact url is no longer valid
+var errURLExpired = errors.New("artifact url expired")
+
+// A downloader fetches artifacts to disk and keeps track of the outcome
+type downloader struct {
+	svc *devicefarm.DeviceFarm
+
+	mu        sync.Mutex
+	urls      map[string]string
+	refreshed map[string]time.Time
+	succeeded int
+	skipped   int
+	failed    []string
+}
+
+func newDownloader(svc *devicefarm.DeviceFarm) *downloader {
+	return &downloader{
+		svc:       svc,
+		urls:      map[string]string{},
+		refreshed: map[string]time.Time{},
+	}
+}
+
+/* Download an artifact, fetching a fresh url when the one we have expired */
+func (d *downloader) downloadArtifact(fileName string, artifact *devicefarm.Artifact) error {
+
+	err := d.download(fileName, artifact)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == errSkipped {
+		d.skipped++
+		return nil
+	}
+
+	if err != nil {
+		d.failed = append(d.failed, fmt.Sprintf("%s: %s", fileName, err))
+		return err
+	}
+
+	d.succeeded++
+	return nil
+}
+
+func (d *downloader) download(fileName string, artifact *devicefarm.Artifact) error {
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	url := d.url(artifact)
+	for attempt := 0; ; attempt++ {
+		err = downloadURL(url, fileName)
+		if err != errURLExpired || attempt > 0 {
+			return err
+		}
+
+		url, err = d.refreshURL(artifact)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *downloader) url(artifact *devicefarm.Artifact) string {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if url, found := d.urls[aws.StringValue(artifact.Arn)]; found {
+		return url
+	}
+
+	return aws.StringValue(artifact.Url)
+}
+
+// refreshURL lists the artifacts of the run again for fresh presigned urls,
+// once for all artifacts of the run unless the last refresh is old as well
+func (d *downloader) refreshURL(artifact *devicefarm.Artifact) (string, error) {
+
+	artifactArn := aws.StringValue(artifact.Arn)
+
+	// Performance samples are listed per job instead
+	if strings.Contains(artifactArn, ":sample:") {
+		return d.refreshSampleURL(artifactArn)
+	}
+
+	runArn := parentArn(artifactArn, "run", 2)
+	if runArn == "" {
+		return "", errURLExpired
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if time.Since(d.refreshed[runArn]) > 5*time.Minute {
+		artifacts, err := listArtifactNodes(d.svc, runArn, artifactFilter{})
+		if err != nil {
+			return "", err
+		}
+
+		for _, m := range artifacts {
+			d.urls[aws.StringValue(m.Artifact.Arn)] = aws.StringValue(m.Artifact.Url)
+		}
+		d.refreshed[runArn] = time.Now()
+	}
+
+	url, found := d.urls[artifactArn]
+	if !found {
+		return "", errors.New("artifact no longer listed on devicefarm")
+	}
+
+	return url, nil
+}
+
+func (d *downloader) refreshSampleURL(sampleArn string) (string, error) {
+
+	jobArn := parentArn(sampleArn, "job", 3)
+	if jobArn == "" {
+		return "", errURLExpired
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if time.Since(d.refreshed[jobArn]) > 5*time.Minute {
+		samples, err := listSampleNodes(d.svc, jobArn)
+		if err != nil {
+			return "", err
+		}
+
+		for _, m := range samples {
+			d.urls[aws.StringValue(m.Sample.Arn)] = aws.StringValue(m.Sample.Url)
+		}
+		d.refreshed[jobArn] = time.Now()
+	}
+
+	url, found := d.urls[sampleArn]
+	if !found {
+		return "", errors.New("sample no longer listed on devicefarm")
+	}
+
+	return url, nil
+}
+
+func (d *downloader) printSummary() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(progress, "- Downloaded %d, skipped %d existing, failed %d\n", d.succeeded, d.skipped, len(d.failed))
+	for _, failure := range d.failed {
+		fmt.Fprintf(progress, "  - %s\n", failure)
+	}
+}
+
+func (d *downloader) err() error {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.failed) > 0 {
+		return fmt.Errorf("%d artifacts failed to download", len(d.failed))
+	}
+
+	return nil
+}
+
+// parentArn derives the Arn of a parent from an artifact, job, suite or test Arn:
+// parentArn(artifactArn, "run", 2) gives the Arn of the run the artifact belongs to
+func parentArn(arn string, kind string, depth int) string {
+
+	parts := strings.SplitN(arn, ":", 7)
+	if len(parts) < 7 {
+		return ""
+	}
+
+	// The last id is the element itself, only its parents can be derived
+	ids := strings.Split(parts[6], "/")
+	if len(ids) <= depth {
+		return ""
+	}
+
+	return strings.Join(parts[:5], ":") + ":" + kind + ":" + strings.Join(ids[:depth], "/")
+}
+
+// errSkipped is returned when the file was downloaded before
+var errSkipped = errors.New("already downloaded")
+
+// remoteSize asks for a single byte to learn the size of the file behind the url
+func remoteSize(url string) (int64, error) {
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return contentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty file can not serve its first byte
+		if resp.Header.Get("Content-Range") == "" {
+			return 0, nil
+		}
+		return contentRangeTotal(resp.Header.Get("Content-Range"))
+	}
+
+	return 0, statusError(resp)
+}
+
+// contentRangeTotal is the size of the file in a Content-Range: "bytes 0-0/1234" or "bytes */1234"
+func contentRangeTotal(contentRange string) (int64, error) {
+
+	slash := strings.LastIndex(contentRange, "/")
+	if slash < 0 {
+		return 0, fmt.Errorf("unexpected Content-Range %q", contentRange)
+	}
+
+	total, err := strconv.ParseInt(strings.TrimSpace(contentRange[slash+1:]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected Content-Range %q", contentRange)
+	}
+
+	return total, nil
+}
+
+func statusError(resp *http.Response) error {
+
+	if resp.StatusCode == http.StatusForbidden {
+		// S3 answers 403 with "Request has expired" for expired presigned urls
+		return errURLExpired
+	}
+
+	return fmt.Errorf("unexpected http status %s", resp.Status)
+}
+
+/* Download a url to a file, resuming a partial download */
+func downloadURL(url string, fileName string) error {
+
+	// Skip files we downloaded before, when they have the same size
+	if info, err := os.Stat(fileName); err == nil {
+		size, err := remoteSize(url)
+		if err != nil {
+			return err
+		}
+		if size == info.Size() {
+			return errSkipped
+		}
+	}
+
+	// Downloads go to a temp file first, so an existing file is never half written
+	partName := fileName + ".part"
+	var offset int64
+	if info, err := os.Stat(partName); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	expected := resp.ContentLength
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The whole file, the range was ignored or there was no partial download
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial download is complete when it has the size of the file
+		total, err := contentRangeTotal(resp.Header.Get("Content-Range"))
+		if err == nil && total == offset {
+			return os.Rename(partName, fileName)
+		}
+
+		// Otherwise it is not a part of this file, start over
+		err = os.Remove(partName)
+		if err != nil {
+			return err
+		}
+		return downloadURL(url, fileName)
+	default:
+		return statusError(resp)
+	}
+
+	file, err := os.OpenFile(partName, flags, 0666)
+	if err != nil {
+		return err
+	}
+
+	size, err := io.Copy(file, resp.Body)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if expected >= 0 && size != expected {
+		return fmt.Errorf("got %d of %d bytes", size, expected)
+	}
+
+	return os.Rename(partName, fileName)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const artifactContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// artifactServer serves artifactContent on /artifact and a failure on the other paths,
+// the Range header of every request is kept
+type artifactServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newArtifactServer() *artifactServer {
+	s := &artifactServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/artifact":
+			http.ServeContent(w, r, "artifact.txt", time.Time{}, strings.NewReader(artifactContent))
+		case "/empty":
+			http.ServeContent(w, r, "empty.txt", time.Time{}, bytes.NewReader(nil))
+		case "/expired":
+			http.Error(w, "Request has expired", http.StatusForbidden)
+		case "/truncated":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(artifactContent))
+		default:
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	}))
+	return s
+}
+
+func (s *artifactServer) lastRange() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ranges) == 0 {
+		return ""
+	}
+	return s.ranges[len(s.ranges)-1]
+}
+
+func TestDownloadURL(t *testing.T) {
+
+	server := newArtifactServer()
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		existing  string
+		part      string
+		err       error
+		content   string
+		lastRange string
+	}{
+		{"new file", "/artifact", "", "", nil, artifactContent, ""},
+		{"same size", "/artifact", strings.Repeat("x", len(artifactContent)), "", errSkipped, strings.Repeat("x", len(artifactContent)), "bytes=0-0"},
+		{"other size", "/artifact", "old", "", nil, artifactContent, ""},
+		{"resume", "/artifact", "", artifactContent[:10], nil, artifactContent, "bytes=10-"},
+		{"complete part", "/artifact", "", artifactContent, nil, artifactContent, "bytes=36-"},
+		{"part too large", "/artifact", "", artifactContent + "garbage", nil, artifactContent, ""},
+		{"empty file", "/empty", "", "", nil, "", ""},
+	}
+
+	for _, test := range tests {
+		dir := tempTestDir(t)
+		defer os.RemoveAll(dir)
+
+		fileName := filepath.Join(dir, "artifact.txt")
+		if test.existing != "" {
+			writeTestFile(t, fileName, test.existing)
+		}
+		if test.part != "" {
+			writeTestFile(t, fileName+".part", test.part)
+		}
+
+		err := downloadURL(server.URL+test.path, fileName)
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if got := readTestFile(t, fileName); got != test.content {
+			t.Errorf("%s: downloaded %q, want %q", test.name, got, test.content)
+		}
+		if got := readTestFile(t, fileName+".part"); got != "" {
+			t.Errorf("%s: left %q in the partial file", test.name, got)
+		}
+		if got := server.lastRange(); got != test.lastRange {
+			t.Errorf("%s: last request asked for range %q, want %q", test.name, got, test.lastRange)
+		}
+	}
+}
+
+func TestDownloadURLFailures(t *testing.T) {
+
+	server := newArtifactServer()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		expired bool
+	}{
+		{"expired url", "/expired", true},
+		{"server error", "/broken", false},
+		{"short body", "/truncated", false},
+	}
+
+	for _, test := range tests {
+		dir := tempTestDir(t)
+		defer os.RemoveAll(dir)
+
+		// The file of an earlier run is never replaced by a failed download
+		fileName := filepath.Join(dir, "artifact.txt")
+		writeTestFile(t, fileName, "old")
+
+		err := downloadURL(server.URL+test.path, fileName)
+		if err == nil {
+			t.Errorf("%s: got no error", test.name)
+			continue
+		}
+		if (err == errURLExpired) != test.expired {
+			t.Errorf("%s: got error %v, want an expired url %v", test.name, err, test.expired)
+		}
+		if got := readTestFile(t, fileName); got != "old" {
+			t.Errorf("%s: file is %q, want the old one", test.name, got)
+		}
+	}
+}
+
+func TestRemoteSize(t *testing.T) {
+
+	server := newArtifactServer()
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want int64
+		err  bool
+	}{
+		{"/artifact", int64(len(artifactContent)), false},
+		{"/empty", 0, false},
+		{"/expired", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := remoteSize(server.URL + test.path)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("remoteSize(%s) = %d, %v, want %d with an error %v", test.path, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestContentRangeTotal(t *testing.T) {
+
+	tests := []struct {
+		contentRange string
+		want         int64
+		err          bool
+	}{
+		{"bytes 0-0/1234", 1234, false},
+		{"bytes */36", 36, false},
+		{"bytes 10-35/ 36", 36, false},
+		{"bytes 0-0/*", 0, true},
+		{"", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := contentRangeTotal(test.contentRange)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("contentRangeTotal(%q) = %d, %v, want %d with an error %v", test.contentRange, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestParentArn(t *testing.T) {
+
+	artifact := "arn:aws:devicefarm:us-west-2:123456789012:artifact:project/run/job/suite/test/artifact"
+	tests := []struct {
+		arn   string
+		kind  string
+		depth int
+		want  string
+	}{
+		{artifact, "run", 2, "arn:aws:devicefarm:us-west-2:123456789012:run:project/run"},
+		{artifact, "job", 3, "arn:aws:devicefarm:us-west-2:123456789012:job:project/run/job"},
+		{"arn:aws:devicefarm:us-west-2:123456789012:run:project/run", "run", 2, ""},
+		{"not an arn", "run", 2, ""},
+	}
+
+	for _, test := range tests {
+		if got := parentArn(test.arn, test.kind, test.depth); got != test.want {
+			t.Errorf("parentArn(%q, %s, %d) = %q, want %q", test.arn, test.kind, test.depth, got, test.want)
+		}
+	}
+}
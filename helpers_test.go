@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempTestDir is a folder for the files of a test, remove it when done
+func tempTestDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "devicefarm-cli-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeTestFile writes a file and the folders it is in
+func writeTestFile(t *testing.T, fileName string, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err == nil {
+		err = ioutil.WriteFile(fileName, []byte(content), 0666)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestFile is the content of a file, or "" when it does not exist
+func readTestFile(t *testing.T, fileName string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// writeTestZip writes a zip with the given names and contents
+func writeTestZip(t *testing.T, fileName string, files [][2]string) {
+	t.Helper()
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	for _, f := range files {
+		entry, err := w.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(f[1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type htmlReport struct {
+	Name      string
+	Result    string
+	Status    string
+	Platform  string
+	Type      string
+	Created   string
+	Arn       string
+	Generated time.Time
+	Minutes   float64
+	Counters  []htmlCounter
+	Merged    []htmlCounter
+	Suites    []string
+	Devices   []htmlDevice
+}
+
+// htmlCounters lists the counters for the summary table, nothing when there are none
+func htmlCounters(c *devicefarm.Counters) []htmlCounter {
+	if c == nil {
+		return nil
+	}
+	return []htmlCounter{
+		{"Total", aws.Int64Value(c.Total)},
+		{"Passed", aws.Int64Value(c.Passed)},
+		{"Failed", aws.Int64Value(c.Failed)},
+		{"Errored", aws.Int64Value(c.Errored)},
+		{"Warned", aws.Int64Value(c.Warned)},
+		{"Skipped", aws.Int64Value(c.Skipped)},
+		{"Stopped", aws.Int64Value(c.Stopped)},
+	}
+}
+
+type htmlCounter struct {
+	Name  string
+	Value int64
+}
+
+type htmlDevice struct {
+	Name        string
+	Result      string
+	Message     string
+	Cells       []string
+	Suites      []htmlSuite
+	Screenshots []htmlLink
+	Logs        []htmlLink
+	Events      []htmlEvent
+	Phases      []htmlPhase
+	Perf        []htmlPerf
+}
+
+type htmlPerf struct {
+	Type string
+	Peak string
+	Avg  string
+	P95  string
+}
+
+type htmlPhase struct {
+	Name     string
+	Result   string
+	Duration time.Duration
+	Commands []htmlCommand
+}
+
+type htmlCommand struct {
+	Command  string
+	ExitCode string
+	Duration time.Duration
+	Failed   bool
+	Output   string
+}
+
+type htmlEvent struct {
+	Kind    string
+	Summary string
+	Stack   string
+	Log     htmlLink
+}
+
+type htmlSuite struct {
+	Name        string
+	Result      string
+	Message     string
+	Tests       []htmlTest
+	Screenshots []htmlLink
+	Logs        []htmlLink
+}
+
+type htmlTest struct {
+	Name        string
+	Result      string
+	Message     string
+	Duration    time.Duration
+	Screenshots []htmlLink
+	Logs        []htmlLink
+}
+
+type htmlLink struct {
+	Name string
+	Href string
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+details { margin: 0.5em 0 0.5em 1em; }
+summary { cursor: pointer; }
+.passed { background: #dff0d8; }
+.failed { background: #f2dede; }
+.errored { background: #f5c6cb; }
+.warned { background: #fcf8e3; }
+.skipped, .stopped, .pending { background: #eee; }
+.message { white-space: pre-wrap; font-family: monospace; font-size: 0.9em; }
+.gallery img { max-height: 240px; margin: 4px; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<table>
+<tr><th>Result</th><td class="{{lower .Result}}">{{.Result}}</td></tr>
+<tr><th>Status</th><td>{{.Status}}</td></tr>
+<tr><th>Platform</th><td>{{.Platform}}</td></tr>
+<tr><th>Type</th><td>{{.Type}}</td></tr>
+<tr><th>Created</th><td>{{.Created}}</td></tr>
+<tr><th>Device minutes</th><td>{{printf "%.2f" .Minutes}}</td></tr>
+<tr><th>Arn</th><td>{{.Arn}}</td></tr>
+</table>
+
+<h2>Summary</h2>
+<table>
+<tr>{{range .Counters}}<th>{{.Name}}</th>{{end}}</tr>
+<tr>{{range .Counters}}<td>{{.Value}}</td>{{end}}</tr>
+</table>
+{{if .Merged}}
+<h2>Merged results</h2>
+<table>
+<tr>{{range .Merged}}<th>{{.Name}}</th>{{end}}</tr>
+<tr>{{range .Merged}}<td>{{.Value}}</td>{{end}}</tr>
+</table>
+{{end}}
+
+<h2>Devices</h2>
+<table>
+<tr><th>Device</th><th>Result</th>{{range .Suites}}<th>{{.}}</th>{{end}}</tr>
+{{range .Devices}}<tr><td>{{.Name}}</td><td class="{{lower .Result}}">{{.Result}}</td>{{range .Cells}}<td class="{{lower .}}">{{.}}</td>{{end}}</tr>
+{{end}}</table>
+
+<h2>Details</h2>
+{{range .Devices}}<details>
+<summary class="{{lower .Result}}">{{.Name}} - {{.Result}}</summary>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+{{range .Events}}<details class="failed">
+<summary>{{.Summary}}{{if .Log.Href}} (<a href="{{.Log.Href}}">{{.Log.Name}}</a>){{end}}</summary>
+{{if .Stack}}<pre class="message">{{.Stack}}</pre>{{end}}
+</details>
+{{end}}{{if .Phases}}<details>
+<summary>Test spec</summary>
+{{range .Phases}}<h4 class="{{lower .Result}}">{{.Name}} - {{.Result}}{{if .Duration}} ({{.Duration}}){{end}}</h4>
+{{if .Commands}}<table>
+<tr><th>Command</th><th>Exit code</th><th>Duration</th></tr>
+{{range .Commands}}<tr{{if .Failed}} class="failed"{{end}}><td><code>{{.Command}}</code>{{if and .Failed .Output}}<pre class="message">{{.Output}}</pre>{{end}}</td><td>{{.ExitCode}}</td><td>{{if .Duration}}{{.Duration}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}</details>
+{{end}}{{if .Perf}}<table>
+<tr><th>Performance</th><th>Peak</th><th>Avg</th><th>P95</th></tr>
+{{range .Perf}}<tr><td>{{.Type}}</td><td>{{.Peak}}</td><td>{{.Avg}}</td><td>{{.P95}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Screenshots}}<div class="gallery">{{range .Screenshots}}<a href="{{.Href}}"><img src="{{.Href}}" alt="{{.Name}}" title="{{.Name}}"></a>{{end}}</div>{{end}}
+{{if .Logs}}<ul>{{range .Logs}}<li><a href="{{.Href}}">{{.Name}}</a></li>{{end}}</ul>{{end}}
+{{range .Suites}}<details>
+<summary class="{{lower .Result}}">{{.Name}} - {{.Result}}</summary>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+{{if .Tests}}<table>
+<tr><th>Test</th><th>Result</th><th>Duration</th><th>Message</th></tr>
+{{range .Tests}}<tr><td>{{.Name}}</td><td class="{{lower .Result}}">{{.Result}}</td><td>{{.Duration}}</td><td><span class="message">{{.Message}}</span>{{range .Logs}}<br><a href="{{.Href}}">{{.Name}}</a>{{end}}{{if .Screenshots}}<div class="gallery">{{range .Screenshots}}<a href="{{.Href}}"><img src="{{.Href}}" alt="{{.Name}}" title="{{.Name}}"></a>{{end}}</div>{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Screenshots}}<div class="gallery">{{range .Screenshots}}<a href="{{.Href}}"><img src="{{.Href}}" alt="{{.Name}}" title="{{.Name}}"></a>{{end}}</div>{{end}}
+{{if .Logs}}<ul>{{range .Logs}}<li><a href="{{.Href}}">{{.Name}}</a></li>{{end}}</ul>{{end}}
+</details>
+{{end}}</details>
+{{end}}
+<p><small>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</small></p>
+</body>
+</html>
+`))
+
+// htmlLinks splits the downloaded artifacts in screenshots and other files,
+// the links are relative to the report so the folder can be moved around
+func htmlLinks(artifacts []*artifactNode, baseDir string) (screenshots []htmlLink, logs []htmlLink) {
+
+	for _, artifact := range artifacts {
+		if artifact.Path == "" {
+			continue
+		}
+
+		href := artifact.Path
+		if rel, err := filepath.Rel(baseDir, artifact.Path); err == nil {
+			href = rel
+		}
+
+		link := htmlLink{
+			Name: filepath.Base(artifact.Path),
+			Href: filepath.ToSlash(href),
+		}
+
+		if artifact.Category == "SCREENSHOT" {
+			screenshots = append(screenshots, link)
+		} else {
+			logs = append(logs, link)
+		}
+	}
+
+	return screenshots, logs
+}
+
+/* Convert a run into the data of the HTML report */
+func newHTMLReport(tree *runTree, baseDir string) htmlReport {
+
+	run := tree.Run
+	report := htmlReport{
+		Name:      aws.StringValue(run.Name),
+		Result:    aws.StringValue(run.Result),
+		Status:    aws.StringValue(run.Status),
+		Platform:  aws.StringValue(run.Platform),
+		Type:      aws.StringValue(run.Type),
+		Arn:       aws.StringValue(run.Arn),
+		Generated: time.Now(),
+	}
+
+	if run.Created != nil {
+		report.Created = run.Created.Format("2006-01-02 15:04:05 MST")
+	}
+
+	if tree.Run.DeviceMinutes != nil {
+		report.Minutes = aws.Float64Value(tree.Run.DeviceMinutes.Total)
+	}
+
+	report.Counters = htmlCounters(tree.Run.Counters)
+	report.Merged = htmlCounters(tree.Merged)
+
+	// The matrix has a column for every suite name seen on any device
+	seen := map[string]bool{}
+	for _, job := range tree.Jobs {
+		for _, suite := range job.Suites {
+			name := aws.StringValue(suite.Suite.Name)
+			if !seen[name] {
+				seen[name] = true
+				report.Suites = append(report.Suites, name)
+			}
+		}
+	}
+
+	for _, job := range tree.Jobs {
+		device := htmlDevice{
+			Name:    jobFriendlyName(job.Job),
+			Result:  aws.StringValue(job.Job.Result),
+			Message: aws.StringValue(job.Job.Message),
+		}
+		device.Screenshots, device.Logs = htmlLinks(job.Artifacts, baseDir)
+		for _, event := range job.Events {
+			if !event.isCrash() {
+				continue
+			}
+			e := htmlEvent{
+				Kind:    event.Kind,
+				Summary: strings.TrimSpace(event.Signature + " " + event.summary()),
+				Stack:   strings.Join(event.Stack, "\n"),
+			}
+			if event.Log != "" {
+				e.Log = htmlLink{Name: fmt.Sprintf("%s:%d", filepath.Base(event.Log), event.Line), Href: event.Log}
+				if rel, err := filepath.Rel(baseDir, event.Log); err == nil {
+					e.Log.Href = filepath.ToSlash(rel)
+				}
+			}
+			device.Events = append(device.Events, e)
+		}
+
+		// Only the first failing command is highlighted, the rest often fails because of it
+		_, failed := firstFailure(job)
+		for _, phase := range job.Phases {
+			p := htmlPhase{Name: phase.Name, Result: "PASSED", Duration: phase.Duration}
+			if (phase.ExitCode != nil && *phase.ExitCode != 0) || phase.failed() != nil {
+				p.Result = "FAILED"
+			}
+			for _, command := range phase.Commands {
+				c := htmlCommand{
+					Command:  command.Command,
+					Duration: command.Duration,
+					Failed:   command == failed,
+					Output:   strings.Join(command.Output, "\n"),
+				}
+				if command.ExitCode != nil {
+					c.ExitCode = fmt.Sprint(*command.ExitCode)
+				}
+				p.Commands = append(p.Commands, c)
+			}
+			device.Phases = append(device.Phases, p)
+		}
+
+		for _, series := range job.Perf {
+			device.Perf = append(device.Perf, htmlPerf{
+				Type: series.Type,
+				Peak: formatPerf(series.Peak, series.Type),
+				Avg:  formatPerf(series.Avg, series.Type),
+				P95:  formatPerf(series.P95, series.Type),
+			})
+		}
+
+		results := map[string]string{}
+		for _, suite := range job.Suites {
+			s := htmlSuite{
+				Name:    aws.StringValue(suite.Suite.Name),
+				Result:  aws.StringValue(suite.Suite.Result),
+				Message: aws.StringValue(suite.Suite.Message),
+			}
+			results[s.Name] = s.Result
+
+			// Artifacts of a test are shown with the test, the rest with the suite
+			owned := map[*artifactNode]bool{}
+			for _, test := range suite.Tests {
+				t := htmlTest{
+					Name:     aws.StringValue(test.Test.Name),
+					Result:   aws.StringValue(test.Test.Result),
+					Message:  aws.StringValue(test.Test.Message),
+					Duration: testDuration(test.Test.Started, test.Test.Stopped, test.Test.DeviceMinutes).Round(time.Millisecond),
+				}
+				t.Screenshots, t.Logs = htmlLinks(test.Artifacts, baseDir)
+				for _, artifact := range test.Artifacts {
+					owned[artifact] = true
+				}
+				s.Tests = append(s.Tests, t)
+			}
+
+			rest := []*artifactNode{}
+			for _, artifact := range suite.Artifacts {
+				if !owned[artifact] {
+					rest = append(rest, artifact)
+				}
+			}
+			s.Screenshots, s.Logs = htmlLinks(rest, baseDir)
+
+			device.Suites = append(device.Suites, s)
+		}
+
+		for _, name := range report.Suites {
+			device.Cells = append(device.Cells, results[name])
+		}
+
+		report.Devices = append(report.Devices, device)
+	}
+
+	return report
+}
+
+/* Write a run as a static HTML page */
+func writeHTMLReport(tree *runTree, fileName string) error {
+
+	baseDir := filepath.Dir(fileName)
+	err := os.MkdirAll(baseDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return htmlReportTemplate.Execute(file, newHTMLReport(tree, baseDir))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Timestamp  string           `xml:"timestamp,attr,omitempty"`
+	Hostname   string           `xml:"hostname,attr,omitempty"`
+	Properties []junitProperty  `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase  `xml:"testcase"`
+	Suites     []junitTestSuite `xml:"testsuite,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+/* Convert a run into JUnit testsuites, one per device and suite */
+func junitReport(tree *runTree) junitTestSuites {
+
+	report := junitTestSuites{Name: aws.StringValue(tree.Run.Name)}
+	var total time.Duration
+
+	for _, job := range tree.Jobs {
+		device := jobFriendlyName(job.Job)
+
+		for _, suite := range job.Suites {
+			name := fmt.Sprintf("%s - %s", device, aws.StringValue(suite.Suite.Name))
+			junitSuite := junitTestSuite{
+				Name:     name,
+				Hostname: device,
+				Properties: []junitProperty{
+					{Name: "device", Value: aws.StringValue(job.Job.Name)},
+					{Name: "job", Value: aws.StringValue(job.Job.Arn)},
+					{Name: "suite", Value: aws.StringValue(suite.Suite.Arn)},
+				},
+			}
+
+			if job.Job.Device != nil {
+				junitSuite.Properties = append(junitSuite.Properties,
+					junitProperty{Name: "os", Value: aws.StringValue(job.Job.Device.Os)},
+					junitProperty{Name: "platform", Value: aws.StringValue(job.Job.Device.Platform)},
+				)
+			}
+
+			if suite.Suite.Started != nil {
+				junitSuite.Timestamp = suite.Suite.Started.UTC().Format("2006-01-02T15:04:05")
+			}
+
+			var suiteTime time.Duration
+			for _, test := range suite.Tests {
+				duration := testDuration(test.Test.Started, test.Test.Stopped, test.Test.DeviceMinutes)
+				suiteTime += duration
+
+				testCase := junitTestCase{
+					Name:      aws.StringValue(test.Test.Name),
+					Classname: name,
+					Time:      junitSeconds(duration),
+				}
+
+				message := aws.StringValue(test.Test.Message)
+				result := aws.StringValue(test.Test.Result)
+				switch result {
+				case "FAILED":
+					testCase.Failure = &junitMessage{Message: message, Type: result, Body: message}
+					junitSuite.Failures++
+				case "ERRORED":
+					testCase.Error = &junitMessage{Message: message, Type: result, Body: message}
+					junitSuite.Errors++
+				case "SKIPPED", "STOPPED", "PENDING":
+					testCase.Skipped = &junitMessage{Message: strings.TrimSpace(strings.ToLower(result) + " " + message)}
+					junitSuite.Skipped++
+				}
+
+				// Link the artifacts the way the junit attachments plugin understands
+				out := []string{}
+				if result == "WARNED" && message != "" {
+					out = append(out, "WARNED: "+message)
+				}
+				for _, artifact := range test.Artifacts {
+					if artifact.Path != "" {
+						out = append(out, fmt.Sprintf("[[ATTACHMENT|%s]]", artifact.Path))
+					}
+				}
+				testCase.SystemOut = strings.Join(out, "\n")
+
+				junitSuite.TestCases = append(junitSuite.TestCases, testCase)
+				junitSuite.Tests++
+			}
+
+			junitSuite.Time = junitSeconds(suiteTime)
+			total += suiteTime
+
+			report.Tests += junitSuite.Tests
+			report.Failures += junitSuite.Failures
+			report.Errors += junitSuite.Errors
+			report.Skipped += junitSuite.Skipped
+			report.Suites = append(report.Suites, junitSuite)
+		}
+	}
+
+	report.Time = junitSeconds(total)
+	return report
+}
+
+/* Write a run as JUnit XML */
+func writeJUnitReport(tree *runTree, fileName string) error {
+
+	data, err := xml.MarshalIndent(junitReport(tree), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, append([]byte(xml.Header), data...), 0666)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultReportLayout keeps the layout the report always had
+const defaultReportLayout = "{job} - {model} - {os}/{suite}/{index}_{name}.{ext}"
+
+const defaultDownloadLayout = "{index}-{name}.{ext}"
+
+// artifactFields are the values a layout can refer to as {run}, {device}, ...
+type artifactFields struct {
+	Run      string
+	Job      string
+	Device   string
+	Model    string
+	Os       string
+	Platform string
+	Suite    string
+	Test     string
+	Type     string
+	Category string
+	Name     string
+	Ext      string
+	Index    int
+}
+
+var unsafeFileChars = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_",
+	"\"", "_", "<", "_", ">", "_", "|", "_", "\x00", "_",
+)
+
+// sanitizeFileName makes a name safe to use as a single path element on any OS
+func sanitizeFileName(name string) string {
+
+	name = unsafeFileChars.Replace(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 32 {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Windows does not like trailing dots and spaces
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+
+	return name
+}
+
+func newArtifactFields(artifact *artifactNode, index int) artifactFields {
+	return artifactFields{
+		Type:     aws.StringValue(artifact.Artifact.Type),
+		Category: artifact.Category,
+		Name:     aws.StringValue(artifact.Artifact.Name),
+		Ext:      strings.TrimPrefix(aws.StringValue(artifact.Artifact.Extension), "."),
+		Index:    index,
+	}
+}
+
+func (f *artifactFields) setJob(job *devicefarm.Job) {
+	f.Job = aws.StringValue(job.Name)
+	if job.Device != nil {
+		f.Device = aws.StringValue(job.Device.Name)
+		f.Model = aws.StringValue(job.Device.Model)
+		f.Os = aws.StringValue(job.Device.Os)
+		f.Platform = aws.StringValue(job.Device.Platform)
+	}
+}
+
+// renderLayout fills in a layout like "{run}/{device}-{os}/{suite}/{type}/{name}.{ext}",
+// every value is sanitized so it can not add directories of its own
+func renderLayout(layout string, fields artifactFields) string {
+
+	values := []string{
+		"{run}", fields.Run,
+		"{job}", fields.Job,
+		"{device}", fields.Device,
+		"{model}", fields.Model,
+		"{os}", fields.Os,
+		"{platform}", fields.Platform,
+		"{suite}", fields.Suite,
+		"{test}", fields.Test,
+		"{type}", fields.Type,
+		"{category}", fields.Category,
+		"{name}", fields.Name,
+		"{ext}", fields.Ext,
+		"{index}", strconv.Itoa(fields.Index),
+	}
+	for i := 1; i < len(values); i += 2 {
+		values[i] = unsafeFileChars.Replace(values[i])
+	}
+
+	rendered := strings.NewReplacer(values...).Replace(layout)
+
+	// An empty extension should not leave a dangling dot
+	rendered = strings.TrimSuffix(rendered, ".")
+
+	parts := strings.Split(filepath.ToSlash(rendered), "/")
+	clean := []string{}
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		clean = append(clean, sanitizeFileName(part))
+	}
+
+	return filepath.Join(clean...)
+}
+
+func layoutNeedsHierarchy(layout string) bool {
+	for _, field := range []string{"{run}", "{job}", "{device}", "{model}", "{os}", "{platform}", "{suite}", "{test}"} {
+		if strings.Contains(layout, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// A pathAllocator hands out file names that do not collide,
+// "name.png" becomes "name (2).png" when it was handed out before
+type pathAllocator struct {
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+func newPathAllocator() *pathAllocator {
+	return &pathAllocator{used: map[string]bool{}}
+}
+
+func (a *pathAllocator) allocate(fileName string) string {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	candidate := fileName
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for i := 2; a.used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+
+	// Compare case insensitive, not all file systems tell the difference
+	a.used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// An arnNames looks up the names of the run, job, suite and test an artifact belongs to
+type arnNames struct {
+	svc   *devicefarm.DeviceFarm
+	names map[string]string
+	jobs  map[string]*devicefarm.Job
+}
+
+func newArnNames(svc *devicefarm.DeviceFarm) *arnNames {
+	return &arnNames{
+		svc:   svc,
+		names: map[string]string{},
+		jobs:  map[string]*devicefarm.Job{},
+	}
+}
+
+func (n *arnNames) fill(fields *artifactFields, artifactArn string) error {
+
+	if runArn := parentArn(artifactArn, "run", 2); runArn != "" {
+		name, found := n.names[runArn]
+		if !found {
+			resp, err := n.svc.GetRun(&devicefarm.GetRunInput{Arn: aws.String(runArn)})
+			if err != nil {
+				return err
+			}
+			name = aws.StringValue(resp.Run.Name)
+			n.names[runArn] = name
+		}
+		fields.Run = name
+	}
+
+	if jobArn := parentArn(artifactArn, "job", 3); jobArn != "" {
+		job, found := n.jobs[jobArn]
+		if !found {
+			resp, err := n.svc.GetJob(&devicefarm.GetJobInput{Arn: aws.String(jobArn)})
+			if err != nil {
+				return err
+			}
+			job = resp.Job
+			n.jobs[jobArn] = job
+		}
+		fields.setJob(job)
+	}
+
+	if suiteArn := parentArn(artifactArn, "suite", 4); suiteArn != "" {
+		name, found := n.names[suiteArn]
+		if !found {
+			resp, err := n.svc.GetSuite(&devicefarm.GetSuiteInput{Arn: aws.String(suiteArn)})
+			if err != nil {
+				return err
+			}
+			name = aws.StringValue(resp.Suite.Name)
+			n.names[suiteArn] = name
+		}
+		fields.Suite = name
+	}
+
+	if testArn := parentArn(artifactArn, "test", 5); testArn != "" {
+		name, found := n.names[testArn]
+		if !found {
+			resp, err := n.svc.GetTest(&devicefarm.GetTestInput{Arn: aws.String(testArn)})
+			if err != nil {
+				return err
+			}
+			name = aws.StringValue(resp.Test.Name)
+			n.names[testArn] = name
+		}
+		fields.Test = name
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Homepage feature", "Homepage feature"},
+		{"a/b\\c:d*e?f\"g<h>i|j", "a_b_c_d_e_f_g_h_i_j"},
+		{"tab\there\nnewline", "tab_here_newline"},
+		{"  trailing dots... ", "trailing dots"},
+		{"..", "_"},
+		{".", "_"},
+		{"", "_"},
+		{"Pixel 4 - 11.0", "Pixel 4 - 11.0"},
+		{"Écran d'accueil", "Écran d'accueil"},
+	}
+
+	for _, test := range tests {
+		if got := sanitizeFileName(test.name); got != test.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenderLayout(t *testing.T) {
+
+	fields := artifactFields{
+		Run:      "nightly",
+		Job:      "Google Pixel 4",
+		Device:   "Google Pixel 4",
+		Model:    "Pixel 4",
+		Os:       "11",
+		Platform: "ANDROID",
+		Suite:    "Tests Suite",
+		Test:     "login",
+		Type:     "SCREENSHOT",
+		Category: "SCREENSHOT",
+		Name:     "0_login",
+		Ext:      "png",
+		Index:    3,
+	}
+
+	tests := []struct {
+		layout string
+		fields artifactFields
+		want   string
+	}{
+		{defaultReportLayout, fields, "Google Pixel 4 - Pixel 4 - 11/Tests Suite/3_0_login.png"},
+		{defaultDownloadLayout, fields, "3-0_login.png"},
+		{"{run}/{platform}/{category}/{test}/{name}.{ext}", fields, "nightly/ANDROID/SCREENSHOT/login/0_login.png"},
+		{"{suite}/{name}.{ext}", artifactFields{Suite: "../../etc", Name: "passwd", Ext: "txt"}, ".._.._etc/passwd.txt"},
+		{"{suite}/{name}.{ext}", artifactFields{Suite: "..", Name: "a/b", Ext: "log"}, "_/a_b.log"},
+		{"{suite}/{test}/{name}.{ext}", artifactFields{Name: "Logcat"}, "Logcat"},
+		{"{job}: {name}.{ext}", artifactFields{Job: "Job", Name: "Video", Ext: "mp4"}, "Job_ Video.mp4"},
+	}
+
+	for _, test := range tests {
+		if got := renderLayout(test.layout, test.fields); got != filepath.FromSlash(test.want) {
+			t.Errorf("renderLayout(%q) = %q, want %q", test.layout, got, test.want)
+		}
+	}
+}
+
+func TestLayoutNeedsHierarchy(t *testing.T) {
+
+	tests := []struct {
+		layout string
+		want   bool
+	}{
+		{defaultReportLayout, true},
+		{defaultDownloadLayout, false},
+		{"{type}/{name}.{ext}", false},
+		{"{run}/{name}.{ext}", true},
+	}
+
+	for _, test := range tests {
+		if got := layoutNeedsHierarchy(test.layout); got != test.want {
+			t.Errorf("layoutNeedsHierarchy(%q) = %v, want %v", test.layout, got, test.want)
+		}
+	}
+}
+
+func TestPathAllocator(t *testing.T) {
+
+	paths := newPathAllocator()
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{"report/Video.mp4", "report/Video.mp4"},
+		{"report/Video.mp4", "report/Video (2).mp4"},
+		{"report/video.MP4", "report/video (3).MP4"},
+		{"report/Video (2).mp4", "report/Video (2) (2).mp4"},
+		{"report/Logcat", "report/Logcat"},
+		{"report/Logcat", "report/Logcat (2)"},
+		{"other/Video.mp4", "other/Video.mp4"},
+	}
+
+	for _, test := range tests {
+		if got := paths.allocate(test.fileName); got != test.want {
+			t.Errorf("allocate(%q) = %q, want %q", test.fileName, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+// logTypes are suggested for --type, any artifact type or category works
+var logTypes = []string{"TESTSPEC_OUTPUT", "DEVICE_LOG", "APPIUM_SERVER_OUTPUT", "INSTRUMENTATION_OUTPUT", "MESSAGE_LOG"}
+
+/* Print the logs of a job, with follow it waits for new logs until the job completes */
+func showLogs(svc *devicefarm.DeviceFarm, jobArn string, types []string, grep string, follow bool, interval time.Duration) error {
+
+	var pattern *regexp.Regexp
+	if grep != "" {
+		var err error
+		pattern, err = regexp.Compile(grep)
+		if err != nil {
+			return err
+		}
+	}
+
+	filter := newArtifactFilter(types, nil, nil)
+	if len(filter.Types) == 0 {
+		filter.Types = []string{"LOG"}
+	}
+
+	printed := map[string]bool{}
+	status := ""
+	for {
+		// Device Farm uploads the logs when a suite ends, so while following we
+		// look at the status first to not miss the logs uploaded at completion
+		if follow {
+			resp, err := svc.GetJob(&devicefarm.GetJobInput{Arn: aws.String(jobArn)})
+			if err != nil {
+				return err
+			}
+			if aws.StringValue(resp.Job.Status) != status {
+				status = aws.StringValue(resp.Job.Status)
+				fmt.Fprintf(os.Stderr, "- Job %s is %s\n", aws.StringValue(resp.Job.Name), status)
+			}
+		}
+
+		artifacts, err := listArtifactNodes(svc, jobArn, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, artifact := range artifacts {
+			arn := aws.StringValue(artifact.Artifact.Arn)
+			if printed[arn] {
+				continue
+			}
+			printed[arn] = true
+
+			// Headers go to stderr so the output can be piped as plain log lines
+			fmt.Fprintf(os.Stderr, "==> %s (%s) <==\n", artifactFileName(artifact.Artifact), aws.StringValue(artifact.Artifact.Type))
+			err := streamLog(aws.StringValue(artifact.Artifact.Url), pattern, os.Stdout)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %s", artifactFileName(artifact.Artifact), err)
+			}
+		}
+
+		if !follow || status == devicefarm.ExecutionStatusCompleted {
+			break
+		}
+
+		time.Sleep(interval)
+	}
+
+	if len(printed) == 0 {
+		fmt.Fprintf(os.Stderr, "- No logs found for %s\n", jobArn)
+	}
+
+	return nil
+}
+
+// streamLog copies a log to the output line by line, keeping the lines matching the pattern
+func streamLog(url string, pattern *regexp.Regexp, out io.Writer) error {
+
+	resp, err := downloadClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	if pattern == nil {
+		_, err := io.Copy(out, resp.Body)
+		return err
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 && pattern.MatchString(line) {
+			if _, err := io.WriteString(out, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
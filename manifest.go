@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const manifestVersion = 1
+
+// A manifest lists where every downloaded artifact came from
+type manifest struct {
+	Version   int             `json:"version"`
+	Generated time.Time       `json:"generated"`
+	Artifacts []manifestEntry `json:"artifacts"`
+}
+
+type manifestEntry struct {
+	Arn       string `json:"arn"`
+	Type      string `json:"type"`
+	Category  string `json:"category"`
+	Name      string `json:"name"`
+	Extension string `json:"extension"`
+	Run       string `json:"run,omitempty"`
+	Job       string `json:"job,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Os        string `json:"os,omitempty"`
+	Platform  string `json:"platform,omitempty"`
+	Suite     string `json:"suite,omitempty"`
+	Test      string `json:"test,omitempty"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Sha256    string `json:"sha256"`
+}
+
+func newManifestEntry(artifact *artifactNode, fields artifactFields) manifestEntry {
+	return manifestEntry{
+		Arn:       aws.StringValue(artifact.Artifact.Arn),
+		Type:      aws.StringValue(artifact.Artifact.Type),
+		Category:  artifact.Category,
+		Name:      aws.StringValue(artifact.Artifact.Name),
+		Extension: fields.Ext,
+		Run:       fields.Run,
+		Job:       fields.Job,
+		Device:    fields.Device,
+		Os:        fields.Os,
+		Platform:  fields.Platform,
+		Suite:     fields.Suite,
+		Test:      fields.Test,
+		Path:      artifact.Path,
+	}
+}
+
+/* List the downloaded artifacts of a run for the manifest */
+func manifestFromTree(tree *runTree) []manifestEntry {
+
+	entries := []manifestEntry{}
+	for _, job := range tree.Jobs {
+		// Samples are downloaded as artifacts of the job in the SAMPLE category
+		for _, sample := range job.Samples {
+			if sample.Path == "" {
+				continue
+			}
+
+			artifact := &artifactNode{Artifact: sampleArtifact(sample.Sample), Category: "SAMPLE", Path: sample.Path}
+			fields := newArtifactFields(artifact, 0)
+			fields.Run = aws.StringValue(tree.Run.Name)
+			fields.setJob(job.Job)
+			entries = append(entries, newManifestEntry(artifact, fields))
+		}
+
+		for _, artifact := range job.Artifacts {
+			if artifact.Path == "" {
+				continue
+			}
+
+			fields := newArtifactFields(artifact, 0)
+			fields.Run = aws.StringValue(tree.Run.Name)
+			fields.setJob(job.Job)
+			entries = append(entries, newManifestEntry(artifact, fields))
+		}
+
+		for _, suite := range job.Suites {
+			tests := map[*artifactNode]string{}
+			for _, test := range suite.Tests {
+				for _, artifact := range test.Artifacts {
+					tests[artifact] = aws.StringValue(test.Test.Name)
+				}
+			}
+
+			for _, artifact := range suite.Artifacts {
+				if artifact.Path == "" {
+					continue
+				}
+
+				fields := newArtifactFields(artifact, 0)
+				fields.Run = aws.StringValue(tree.Run.Name)
+				fields.setJob(job.Job)
+				fields.Suite = aws.StringValue(suite.Suite.Name)
+				fields.Test = tests[artifact]
+				entries = append(entries, newManifestEntry(artifact, fields))
+			}
+		}
+	}
+
+	return entries
+}
+
+func fileSha256(fileName string) (int64, string, error) {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+/* Write manifest.json in the output directory, paths are relative to it */
+func writeManifest(outDir string, entries []manifestEntry) (string, error) {
+
+	doc := manifest{
+		Version:   manifestVersion,
+		Generated: time.Now(),
+		Artifacts: []manifestEntry{},
+	}
+
+	for _, entry := range entries {
+		size, sum, err := fileSha256(entry.Path)
+		if err != nil {
+			return "", err
+		}
+		entry.Size = size
+		entry.Sha256 = sum
+
+		if rel, err := filepath.Rel(outDir, entry.Path); err == nil {
+			entry.Path = filepath.ToSlash(rel)
+		}
+		doc.Artifacts = append(doc.Artifacts, entry)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(outDir, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	fileName := filepath.Join(outDir, "manifest.json")
+	return fileName, ioutil.WriteFile(fileName, data, 0666)
+}
+
+/* Pack a directory into a .tar.gz, .tgz or .zip archive */
+func writeArchive(srcDir string, archive string) error {
+
+	lower := strings.ToLower(archive)
+	isZip := strings.HasSuffix(lower, ".zip")
+	if !isZip && !strings.HasSuffix(lower, ".tar.gz") && !strings.HasSuffix(lower, ".tgz") {
+		return errors.New("archive should end in .tar.gz, .tgz or .zip: " + archive)
+	}
+
+	err := os.MkdirAll(filepath.Dir(archive), 0777)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	archivePath, _ := filepath.Abs(archive)
+	prefix := filepath.Base(filepath.Clean(srcDir))
+
+	var add func(fileName string, rel string, info os.FileInfo) error
+	var finish func() error
+
+	if isZip {
+		zw := zip.NewWriter(out)
+		add = func(fileName string, rel string, info os.FileInfo) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			header.Method = zip.Deflate
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			return copyFileTo(w, fileName)
+		}
+		finish = zw.Close
+	} else {
+		gw := gzip.NewWriter(out)
+		tw := tar.NewWriter(gw)
+		add = func(fileName string, rel string, info os.FileInfo) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			err = tw.WriteHeader(header)
+			if err != nil {
+				return err
+			}
+			return copyFileTo(tw, fileName)
+		}
+		finish = func() error {
+			err := tw.Close()
+			if err != nil {
+				return err
+			}
+			return gw.Close()
+		}
+	}
+
+	err = filepath.Walk(srcDir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(fileName, ".part") {
+			return nil
+		}
+
+		// Do not pack the archive into itself when it is written in the directory
+		if abs, _ := filepath.Abs(fileName); abs == archivePath {
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcDir, fileName)
+		if err != nil {
+			return err
+		}
+
+		return add(fileName, filepath.ToSlash(filepath.Join(prefix, rel)), info)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = finish()
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+func copyFileTo(w io.Writer, fileName string) error {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
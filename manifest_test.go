@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestFromTree(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"CPU", "Logcat.logcat", "home.png"} {
+		writeTestFile(t, filepath.Join(dir, name), name)
+	}
+
+	job := testJob("Pixel 4", "11", "login", "PASSED")
+	job.Job.Device.Platform = aws.String("ANDROID")
+	job.Samples = []*sampleNode{
+		{Sample: &devicefarm.Sample{Arn: aws.String("arn:sample:1"), Type: aws.String("CPU")}, Path: filepath.Join(dir, "CPU")},
+		{Sample: &devicefarm.Sample{Arn: aws.String("arn:sample:2"), Type: aws.String("MEMORY")}},
+	}
+	job.Artifacts = []*artifactNode{
+		{Artifact: &devicefarm.Artifact{Arn: aws.String("arn:artifact:1"), Type: aws.String("DEVICE_LOG"), Name: aws.String("Logcat"), Extension: aws.String("logcat")}, Category: "FILE", Path: filepath.Join(dir, "Logcat.logcat")},
+		{Artifact: &devicefarm.Artifact{Arn: aws.String("arn:artifact:2"), Type: aws.String("VIDEO"), Name: aws.String("Video")}, Category: "FILE"},
+	}
+	screenshot := &artifactNode{Artifact: &devicefarm.Artifact{Arn: aws.String("arn:artifact:3"), Type: aws.String("SCREENSHOT"), Name: aws.String("home"), Extension: aws.String("png")}, Category: "SCREENSHOT", Path: filepath.Join(dir, "home.png")}
+	job.Suites[0].Artifacts = []*artifactNode{screenshot}
+	job.Suites[0].Tests[0].Artifacts = []*artifactNode{screenshot}
+
+	entries := manifestFromTree(testRun("nightly", job))
+
+	expected := []manifestEntry{
+		{Arn: "arn:sample:1", Type: "CPU", Category: "SAMPLE", Name: "CPU", Run: "nightly", Job: "Pixel 4", Device: "Pixel 4", Os: "11", Platform: "ANDROID", Path: filepath.Join(dir, "CPU")},
+		{Arn: "arn:artifact:1", Type: "DEVICE_LOG", Category: "FILE", Name: "Logcat", Extension: "logcat", Run: "nightly", Job: "Pixel 4", Device: "Pixel 4", Os: "11", Platform: "ANDROID", Path: filepath.Join(dir, "Logcat.logcat")},
+		{Arn: "arn:artifact:3", Type: "SCREENSHOT", Category: "SCREENSHOT", Name: "home", Extension: "png", Run: "nightly", Job: "Pixel 4", Device: "Pixel 4", Os: "11", Platform: "ANDROID", Suite: "Tests Suite", Test: "login", Path: filepath.Join(dir, "home.png")},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(expected), entries)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("entry %d is %+v, want %+v", i, entries[i], expected[i])
+		}
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, filepath.Join(dir, "Pixel 4", "CPU"), "abc")
+	entries := []manifestEntry{{Arn: "arn:sample:1", Category: "SAMPLE", Path: filepath.Join(dir, "Pixel 4", "CPU")}}
+	fileName, err := writeManifest(dir, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc manifest
+	if err := json.Unmarshal([]byte(readTestFile(t, fileName)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != manifestVersion || len(doc.Artifacts) != 1 {
+		t.Fatalf("unexpected manifest %+v", doc)
+	}
+
+	entry := doc.Artifacts[0]
+	if entry.Path != "Pixel 4/CPU" {
+		t.Errorf("path is %q, want it relative to the output directory", entry.Path)
+	}
+	if entry.Size != 3 || entry.Sha256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("size %d and sha256 %s do not match the file", entry.Size, entry.Sha256)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// problemResults is the order in which problems are shown, worst first
+var problemResults = []string{"ERRORED", "FAILED", "STOPPED", "WARNED", "SKIPPED"}
+
+func markdownCell(value string) string {
+	value = strings.Replace(value, "|", "\\|", -1)
+	return strings.Replace(strings.TrimSpace(value), "\n", "<br>", -1)
+}
+
+func markdownCounters(c *devicefarm.Counters) []string {
+	if c == nil {
+		c = &devicefarm.Counters{}
+	}
+	return []string{
+		fmt.Sprint(aws.Int64Value(c.Passed)),
+		fmt.Sprint(aws.Int64Value(c.Failed)),
+		fmt.Sprint(aws.Int64Value(c.Errored)),
+		fmt.Sprint(aws.Int64Value(c.Skipped)),
+		fmt.Sprint(aws.Int64Value(c.Warned)),
+		fmt.Sprint(aws.Int64Value(c.Stopped)),
+		fmt.Sprint(aws.Int64Value(c.Total)),
+	}
+}
+
+func deviceMinutes(minutes *devicefarm.DeviceMinutes) float64 {
+	if minutes == nil {
+		return 0
+	}
+	return aws.Float64Value(minutes.Total)
+}
+
+// sortedProblemResults lists the results with problems, worst first
+func sortedProblemResults(problems map[string][]*devicefarm.UniqueProblem) []string {
+
+	results := []string{}
+	known := map[string]bool{}
+	for _, result := range problemResults {
+		known[result] = true
+		if len(problems[result]) > 0 {
+			results = append(results, result)
+		}
+	}
+
+	others := []string{}
+	for result := range problems {
+		if !known[result] && len(problems[result]) > 0 {
+			others = append(others, result)
+		}
+	}
+	sort.Strings(others)
+
+	return append(results, others...)
+}
+
+/* Render a run as GitHub flavoured Markdown */
+func markdownReport(tree *runTree) string {
+
+	var b bytes.Buffer
+	run := tree.Run
+
+	fmt.Fprintf(&b, "## %s: %s\n\n", markdownCell(aws.StringValue(run.Name)), aws.StringValue(run.Result))
+	fmt.Fprintf(&b, "%s %s run on %d devices, %.2f device minutes\n\n", aws.StringValue(run.Platform), aws.StringValue(run.Type), len(tree.Jobs), deviceMinutes(run.DeviceMinutes))
+
+	b.WriteString("| Passed | Failed | Errored | Skipped | Warned | Stopped | Total |\n")
+	b.WriteString("| ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(&b, "| %s |\n\n", strings.Join(markdownCounters(run.Counters), " | "))
+
+	if tree.Merged != nil {
+		b.WriteString("Merged from the customer artifacts:\n\n")
+		b.WriteString("| Passed | Failed | Errored | Skipped | Warned | Stopped | Total |\n")
+		b.WriteString("| ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+		fmt.Fprintf(&b, "| %s |\n\n", strings.Join(markdownCounters(tree.Merged), " | "))
+	}
+
+	b.WriteString("### Devices\n\n")
+	b.WriteString("| Device | Os | Result | Passed | Failed | Errored | Skipped | Warned | Stopped | Total | Minutes |\n")
+	b.WriteString("| --- | --- | --- | ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	for _, job := range tree.Jobs {
+		osVersion := ""
+		if job.Job.Device != nil {
+			osVersion = aws.StringValue(job.Job.Device.Os)
+		}
+		line := []string{markdownCell(aws.StringValue(job.Job.Name)), osVersion, aws.StringValue(job.Job.Result)}
+		line = append(line, markdownCounters(job.Job.Counters)...)
+		line = append(line, fmt.Sprintf("%.2f", deviceMinutes(job.Job.DeviceMinutes)))
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(line, " | "))
+	}
+	b.WriteString("\n")
+
+	markdownCrashes(&b, tree)
+	markdownTestSpecs(&b, tree)
+	markdownPerf(&b, tree)
+
+	results := sortedProblemResults(tree.Problems)
+	if len(results) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("### Problems\n\n")
+	for _, result := range results {
+		for _, problem := range tree.Problems[result] {
+			message := aws.StringValue(problem.Message)
+			if message == "" {
+				message = "no message"
+			}
+			fmt.Fprintf(&b, "- **%s** %s (%d)\n", result, markdownCell(message), len(problem.Problems))
+
+			for _, p := range problem.Problems {
+				where := []string{}
+				if p.Device != nil {
+					where = append(where, deviceFriendlyName(p.Device))
+				}
+				if p.Suite != nil {
+					where = append(where, aws.StringValue(p.Suite.Name))
+				}
+				if p.Test != nil {
+					where = append(where, aws.StringValue(p.Test.Name))
+				}
+				fmt.Fprintf(&b, "  - %s\n", markdownCell(strings.Join(where, " / ")))
+			}
+		}
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// markdownCrashes lists the crashes found in the device logs, one line per signature
+func markdownCrashes(b *bytes.Buffer, tree *runTree) {
+
+	if len(tree.Crashes) == 0 {
+		return
+	}
+
+	b.WriteString("### Crashes\n\n")
+	b.WriteString("| Signature | Crash | Message | Count | Devices | First seen |\n")
+	b.WriteString("| --- | --- | --- | ---: | --- | --- |\n")
+	for _, group := range tree.Crashes {
+		firstSeen := "new"
+		if len(group.Runs) > 1 {
+			firstSeen = group.FirstSeen.Format("2006-01-02")
+		}
+		line := []string{
+			"`" + group.Id + "`",
+			markdownCell(group.title()),
+			markdownCell(group.Message),
+			fmt.Sprint(group.Count),
+			markdownCell(strings.Join(group.Devices, "\n")),
+			firstSeen,
+		}
+		fmt.Fprintf(b, "| %s |\n", strings.Join(line, " | "))
+	}
+	b.WriteString("\n")
+}
+
+// markdownTestSpecs shows where the test spec first failed on each device
+func markdownTestSpecs(b *bytes.Buffer, tree *runTree) {
+
+	header := false
+	for _, job := range tree.Jobs {
+		phase, command := firstFailure(job)
+		if phase == nil {
+			continue
+		}
+		if !header {
+			b.WriteString("### Test spec failures\n\n")
+			b.WriteString("| Device | Phase | Command | Exit code | Output |\n")
+			b.WriteString("| --- | --- | --- | ---: | --- |\n")
+			header = true
+		}
+
+		commandLine, output := "", ""
+		exitCode := phase.ExitCode
+		if command != nil {
+			commandLine = "`" + strings.Replace(command.Command, "`", "'", -1) + "`"
+			if len(command.Output) > 0 {
+				output = command.Output[len(command.Output)-1]
+			}
+			if command.ExitCode != nil {
+				exitCode = command.ExitCode
+			}
+		}
+		code := ""
+		if exitCode != nil {
+			code = fmt.Sprint(*exitCode)
+		}
+
+		line := []string{markdownCell(jobFriendlyName(job.Job)), phase.Name, markdownCell(commandLine), code, markdownCell(output)}
+		fmt.Fprintf(b, "| %s |\n", strings.Join(line, " | "))
+	}
+
+	if header {
+		b.WriteString("\n")
+	}
+}
+
+// markdownPerf summarizes the performance samples of every device
+func markdownPerf(b *bytes.Buffer, tree *runTree) {
+
+	header := false
+	for _, job := range tree.Jobs {
+		for _, series := range job.Perf {
+			if !header {
+				b.WriteString("### Performance\n\n")
+				b.WriteString("| Device | Type | Peak | Avg | P95 |\n")
+				b.WriteString("| --- | --- | ---: | ---: | ---: |\n")
+				header = true
+			}
+			line := []string{
+				markdownCell(jobFriendlyName(job.Job)),
+				series.Type,
+				formatPerf(series.Peak, series.Type),
+				formatPerf(series.Avg, series.Type),
+				formatPerf(series.P95, series.Type),
+			}
+			fmt.Fprintf(b, "| %s |\n", strings.Join(line, " | "))
+		}
+	}
+
+	if header {
+		b.WriteString("\n")
+	}
+}
+
+/* Write a run as Markdown, "-" writes to stdout */
+func writeMarkdownReport(tree *runTree, fileName string) error {
+
+	report := markdownReport(tree)
+
+	if fileName == "-" {
+		_, err := os.Stdout.WriteString(report)
+		return err
+	}
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, []byte(report), 0666)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"github.com/olekukonko/tablewriter"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A sampleNode is a performance sample file of a job, Path is set once it is downloaded
+type sampleNode struct {
+	Sample *devicefarm.Sample `json:"sample"`
+	Path   string             `json:"path,omitempty"`
+}
+
+// A perfPoint is one measurement, Time is as found in the sample (or its index)
+type perfPoint struct {
+	Time  float64 `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// A perfSeries is the time series of one sample type on one device
+type perfSeries struct {
+	Type   string      `json:"type"`
+	Points []perfPoint `json:"points"`
+	Peak   float64     `json:"peak"`
+	Avg    float64     `json:"avg"`
+	P95    float64     `json:"p95"`
+}
+
+// perfAliases are the short names accepted in budgets
+var perfAliases = map[string]string{
+	"FPS":    devicefarm.SampleTypeNativeFps,
+	"MEM":    devicefarm.SampleTypeMemory,
+	"THREAD": devicefarm.SampleTypeThreads,
+}
+
+// perfUnits describe the sample types the documentation gives a unit for
+var perfUnits = map[string]string{
+	devicefarm.SampleTypeCpu:     "%",
+	devicefarm.SampleTypeMemory:  "kB",
+	devicefarm.SampleTypeThreads: "threads",
+	devicefarm.SampleTypeRxRate:  "B/s",
+	devicefarm.SampleTypeTxRate:  "B/s",
+}
+
+/* List the performance samples of a job */
+func listSampleNodes(svc *devicefarm.DeviceFarm, jobArn string) ([]*sampleNode, error) {
+
+	samples := []*sampleNode{}
+	listReq := &devicefarm.ListSamplesInput{
+		Arn: aws.String(jobArn),
+	}
+	err := svc.ListSamplesPages(listReq, func(page *devicefarm.ListSamplesOutput, lastPage bool) bool {
+		for _, sample := range page.Samples {
+			samples = append(samples, &sampleNode{Sample: sample})
+		}
+		return true
+	})
+
+	return samples, err
+}
+
+// crawlJobWithSamples also lists the samples, only the performance reports need them
+func crawlJobWithSamples(svc *devicefarm.DeviceFarm, job *jobNode) error {
+
+	err := crawlJob(svc, job)
+	if err != nil {
+		return err
+	}
+
+	job.Samples, err = listSampleNodes(svc, *job.Job.Arn)
+	return err
+}
+
+/* List Samples */
+func listSamples(svc *devicefarm.DeviceFarm, jobArn string) {
+
+	samples, err := listSampleNodes(svc, jobArn)
+	failOnErr(err, "error listing samples")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(200)
+	table.SetHeader([]string{"Type", "Arn"})
+	for _, m := range samples {
+		table.Append([]string{aws.StringValue(m.Sample.Type), aws.StringValue(m.Sample.Arn)})
+	}
+	table.Render() // Send output
+}
+
+// sampleArtifact lets the downloader fetch a sample like an artifact
+func sampleArtifact(sample *devicefarm.Sample) *devicefarm.Artifact {
+	return &devicefarm.Artifact{
+		Arn:  sample.Arn,
+		Name: sample.Type,
+		Type: sample.Type,
+		Url:  sample.Url,
+	}
+}
+
+func downloadRunSamples(d *downloader, tree *runTree, concurrency int) {
+
+	samples := []*sampleNode{}
+	for _, job := range tree.Jobs {
+		samples = append(samples, job.Samples...)
+	}
+	errs := make([]error, len(samples))
+
+	forEachOrdered(len(samples), concurrency, func(i int) {
+		errs[i] = d.downloadArtifact(samples[i].Path, sampleArtifact(samples[i].Sample))
+	}, func(i int) {
+		if errs[i] != nil {
+			fmt.Fprintf(progress, "- [SAMPLE] %s failed: %s\n", samples[i].Path, errs[i])
+			samples[i].Path = ""
+			return
+		}
+		fmt.Fprintf(progress, "- [SAMPLE] %s\n", samples[i].Path)
+	})
+}
+
+// parseNumber reads a number, ignoring units and thousands separators
+func parseNumber(value string) (float64, bool) {
+	value = strings.TrimSpace(strings.Trim(value, `"'`))
+	value = strings.TrimRight(value, "%kKmMBbs/ ")
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+	return number, true
+}
+
+var sampleFieldSeparator = regexp.MustCompile(`[,;\t ]+`)
+
+// jsonPoints reads the JSON forms a sample may come in: [1,2], [[t,v]], [{"timestamp":t,"value":v}], {"values":[...]}
+func jsonPoints(data interface{}) []perfPoint {
+
+	points := []perfPoint{}
+
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"values", "data", "samples", "points"} {
+			if nested, found := value[key]; found {
+				return jsonPoints(nested)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			switch item := item.(type) {
+			case float64:
+				points = append(points, perfPoint{Time: float64(i), Value: item})
+			case []interface{}:
+				if len(item) >= 2 {
+					t, okTime := item[0].(float64)
+					v, okValue := item[1].(float64)
+					if okTime && okValue {
+						points = append(points, perfPoint{Time: t, Value: v})
+					}
+				}
+			case map[string]interface{}:
+				v, ok := item["value"].(float64)
+				if !ok {
+					continue
+				}
+				t := float64(i)
+				for _, key := range []string{"timestamp", "time", "t", "x"} {
+					if number, found := item[key].(float64); found {
+						t = number
+						break
+					}
+				}
+				points = append(points, perfPoint{Time: t, Value: v})
+			}
+		}
+	}
+
+	return points
+}
+
+/* Parse a sample file: JSON, or lines of "value" or "time,value" */
+func parseSample(data []byte) []perfPoint {
+
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var decoded interface{}
+		if json.Unmarshal(data, &decoded) == nil {
+			return jsonPoints(decoded)
+		}
+	}
+
+	points := []perfPoint{}
+	for _, line := range strings.Split(trimmed, "\n") {
+		numbers := []float64{}
+		for _, field := range sampleFieldSeparator.Split(strings.TrimSpace(line), -1) {
+			if number, ok := parseNumber(field); ok {
+				numbers = append(numbers, number)
+			}
+		}
+
+		// Header lines have no numbers
+		switch {
+		case len(numbers) == 1:
+			points = append(points, perfPoint{Time: float64(len(points)), Value: numbers[0]})
+		case len(numbers) >= 2:
+			points = append(points, perfPoint{Time: numbers[0], Value: numbers[len(numbers)-1]})
+		}
+	}
+
+	return points
+}
+
+// percentile uses the nearest rank on a sorted copy of the values
+func percentile(values []float64, p float64) float64 {
+
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+func summarizeSeries(series *perfSeries) {
+
+	values := []float64{}
+	sum := 0.0
+	series.Peak = 0
+	for i, point := range series.Points {
+		values = append(values, point.Value)
+		sum += point.Value
+		if i == 0 || point.Value > series.Peak {
+			series.Peak = point.Value
+		}
+	}
+
+	if len(values) > 0 {
+		series.Avg = sum / float64(len(values))
+	}
+	series.P95 = percentile(values, 95)
+}
+
+/* Parse the downloaded samples of every job into summarized series */
+func analyzeSamples(tree *runTree) error {
+
+	for _, job := range tree.Jobs {
+		job.Perf = nil
+
+		for _, sample := range job.Samples {
+			if sample.Path == "" {
+				continue
+			}
+
+			data, err := ioutil.ReadFile(sample.Path)
+			if err != nil {
+				return err
+			}
+
+			series := &perfSeries{Type: aws.StringValue(sample.Sample.Type), Points: parseSample(data)}
+			if len(series.Points) == 0 {
+				continue
+			}
+			summarizeSeries(series)
+			job.Perf = append(job.Perf, series)
+		}
+	}
+
+	return nil
+}
+
+func (s *perfSeries) stat(name string) float64 {
+	switch name {
+	case "avg":
+		return s.Avg
+	case "p95":
+		return s.P95
+	}
+	return s.Peak
+}
+
+func formatPerf(value float64, sampleType string) string {
+	unit := perfUnits[sampleType]
+	if unit == "" {
+		return strconv.FormatFloat(value, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + unit
+}
+
+func printPerf(tree *runTree) {
+	for _, job := range tree.Jobs {
+		for _, series := range job.Perf {
+			fmt.Fprintf(progress, "- [PERF] %s: %s peak %s, avg %s, p95 %s\n", jobFriendlyName(job.Job), series.Type,
+				formatPerf(series.Peak, series.Type), formatPerf(series.Avg, series.Type), formatPerf(series.P95, series.Type))
+		}
+	}
+}
+
+/* Write every point of every series as CSV */
+func writePerfCSV(tree *runTree, fileName string) error {
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"device", "os", "job", "type", "time", "value"})
+	for _, job := range tree.Jobs {
+		osVersion := ""
+		if job.Job.Device != nil {
+			osVersion = aws.StringValue(job.Job.Device.Os)
+		}
+		for _, series := range job.Perf {
+			for _, point := range series.Points {
+				w.Write([]string{
+					aws.StringValue(job.Job.Name),
+					osVersion,
+					aws.StringValue(job.Job.Arn),
+					series.Type,
+					strconv.FormatFloat(point.Time, 'f', -1, 64),
+					strconv.FormatFloat(point.Value, 'f', -1, 64),
+				})
+			}
+		}
+	}
+	w.Flush()
+
+	err = w.Error()
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// A perfBudget is a limit on a statistic of a sample type, "CPU.p95<=80"
+type perfBudget struct {
+	Type  string
+	Stat  string
+	Op    string
+	Limit float64
+}
+
+var perfBudgetExpr = regexp.MustCompile(`^([A-Za-z_]+)(?:\.(peak|avg|p95))?\s*(<=|>=|<|>)\s*([0-9.]+)$`)
+
+/* Parse budgets like "CPU.p95<=80,MEMORY<=400000,FPS.avg>=30", the statistic defaults to peak */
+func parsePerfBudgets(values []string) ([]perfBudget, error) {
+
+	budgets := []perfBudget{}
+	for _, value := range splitList(values) {
+		m := perfBudgetExpr.FindStringSubmatch(strings.TrimSpace(value))
+		if m == nil {
+			return nil, fmt.Errorf("invalid performance budget %q, use TYPE[.peak|.avg|.p95]<=LIMIT", value)
+		}
+
+		sampleType := strings.ToUpper(m[1])
+		if alias, found := perfAliases[sampleType]; found {
+			sampleType = alias
+		}
+
+		if !knownSampleType(sampleType) {
+			return nil, fmt.Errorf("unknown sample type %q in performance budget %q, use one of %s", sampleType, value, strings.Join(devicefarm.SampleType_Values(), ", "))
+		}
+
+		stat := strings.ToLower(m[2])
+		if stat == "" {
+			stat = "peak"
+		}
+
+		limit, _ := strconv.ParseFloat(m[4], 64)
+		budgets = append(budgets, perfBudget{Type: sampleType, Stat: stat, Op: m[3], Limit: limit})
+	}
+
+	return budgets, nil
+}
+
+func knownSampleType(sampleType string) bool {
+	for _, known := range devicefarm.SampleType_Values() {
+		if known == sampleType {
+			return true
+		}
+	}
+	return false
+}
+
+func (b perfBudget) allows(value float64) bool {
+	switch b.Op {
+	case "<=":
+		return value <= b.Limit
+	case "<":
+		return value < b.Limit
+	case ">=":
+		return value >= b.Limit
+	case ">":
+		return value > b.Limit
+	}
+	return true
+}
+
+func (b perfBudget) String() string {
+	return fmt.Sprintf("%s.%s%s%s", b.Type, b.Stat, b.Op, strconv.FormatFloat(b.Limit, 'f', -1, 64))
+}
+
+/* Check the series of every device against the budgets, returns the number of violations */
+func checkPerfBudgets(tree *runTree, budgets []perfBudget) int {
+
+	violations := 0
+	for _, job := range tree.Jobs {
+		for _, budget := range budgets {
+			checked := false
+			for _, series := range job.Perf {
+				if budget.Type != series.Type {
+					continue
+				}
+				checked = true
+				value := series.stat(budget.Stat)
+				if !budget.allows(value) {
+					fmt.Fprintf(progress, "- [BUDGET] %s: %s %s is %s, budget %s\n", jobFriendlyName(job.Job), series.Type, budget.Stat, formatPerf(value, series.Type), budget)
+					violations++
+				}
+			}
+			// A device without samples of the budget's type has nothing to check, that is a violation too
+			if !checked {
+				fmt.Fprintf(progress, "- [BUDGET] %s: no %s samples, budget %s\n", jobFriendlyName(job.Job), budget.Type, budget)
+				violations++
+			}
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseSample(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data string
+		want []perfPoint
+	}{
+		{"values", "12.5\n13\n\n14%\n", []perfPoint{{0, 12.5}, {1, 13}, {2, 14}}},
+		{"csv with header", "time,value\n0,10\n1.5,20\n", []perfPoint{{0, 10}, {1.5, 20}}},
+		{"separators and units", "0; 1000kB\n1\t2000kB\n", []perfPoint{{0, 1000}, {1, 2000}}},
+		{"json numbers", "[1, 2.5, 3]", []perfPoint{{0, 1}, {1, 2.5}, {2, 3}}},
+		{"json pairs", "[[10, 1], [20, 2], [30]]", []perfPoint{{10, 1}, {20, 2}}},
+		{"json objects", `[{"timestamp": 5, "value": 50}, {"value": 60}, {"time": 7}]`, []perfPoint{{5, 50}, {1, 60}}},
+		{"json wrapped", `{"values": [4, 5]}`, []perfPoint{{0, 4}, {1, 5}}},
+		{"garbage", "no numbers here\nNaN\n", []perfPoint{}},
+		{"empty", "", []perfPoint{}},
+	}
+
+	for _, test := range tests {
+		got := parseSample([]byte(test.data))
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: point %d is %v, want %v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+
+	values := []float64{15, 20, 35, 40, 50}
+	tests := []struct {
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{nil, 95, 0},
+		{[]float64{7}, 95, 7},
+		{values, 0, 15},
+		{values, 30, 20},
+		{values, 40, 20},
+		{values, 50, 35},
+		{values, 95, 50},
+		{values, 100, 50},
+		{[]float64{50, 15, 40, 20, 35}, 50, 35},
+	}
+
+	for _, test := range tests {
+		if got := percentile(test.values, test.p); got != test.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", test.values, test.p, got, test.want)
+		}
+	}
+
+	unsorted := []float64{3, 1, 2}
+	percentile(unsorted, 50)
+	if unsorted[0] != 3 || unsorted[1] != 1 || unsorted[2] != 2 {
+		t.Errorf("percentile sorted the values it was given: %v", unsorted)
+	}
+}
+
+func TestParsePerfBudgets(t *testing.T) {
+
+	tests := []struct {
+		values []string
+		want   []perfBudget
+		err    bool
+	}{
+		{[]string{"CPU.p95<=80"}, []perfBudget{{"CPU", "p95", "<=", 80}}, false},
+		{[]string{"memory<400000"}, []perfBudget{{"MEMORY", "peak", "<", 400000}}, false},
+		{[]string{"FPS.avg>=30,thread.peak>2.5"}, []perfBudget{{"NATIVE_FPS", "avg", ">=", 30}, {"THREADS", "peak", ">", 2.5}}, false},
+		{[]string{"CPU <= 80", "MEM.avg>=1"}, []perfBudget{{"CPU", "peak", "<=", 80}, {"MEMORY", "avg", ">=", 1}}, false},
+		{nil, []perfBudget{}, false},
+		{[]string{"CPU==80"}, nil, true},
+		{[]string{"CPU.max<=80"}, nil, true},
+		{[]string{"CPU<=lots"}, nil, true},
+		{[]string{"BATTERY<=80"}, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := parsePerfBudgets(test.values)
+		if (err != nil) != test.err {
+			t.Errorf("parsePerfBudgets(%v) error is %v, want an error %v", test.values, err, test.err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("parsePerfBudgets(%v) = %v, want %v", test.values, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("parsePerfBudgets(%v) budget %d is %v, want %v", test.values, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestCheckPerfBudgets(t *testing.T) {
+
+	progress = ioutil.Discard
+	series := func(sampleType string, values ...float64) *perfSeries {
+		s := &perfSeries{Type: sampleType}
+		for i, value := range values {
+			s.Points = append(s.Points, perfPoint{Time: float64(i), Value: value})
+		}
+		summarizeSeries(s)
+		return s
+	}
+
+	tree := &runTree{Jobs: []*jobNode{
+		{Job: &devicefarm.Job{Name: aws.String("Pixel 4")}, Perf: []*perfSeries{
+			series(devicefarm.SampleTypeCpu, 10, 20, 90),
+			series(devicefarm.SampleTypeNativeFps, 60, 58, 20),
+		}},
+		{Job: &devicefarm.Job{Name: aws.String("Galaxy S20")}, Perf: []*perfSeries{
+			series(devicefarm.SampleTypeCpu, 10, 20, 30),
+		}},
+	}}
+
+	tests := []struct {
+		budgets []string
+		want    int
+	}{
+		{nil, 0},
+		{[]string{"CPU<=95"}, 0},
+		{[]string{"CPU<=80"}, 1},
+		{[]string{"CPU.avg<=30"}, 1},
+		{[]string{"CPU<=10"}, 2},
+		{[]string{"FPS.avg>=30"}, 1},
+		{[]string{"FPS.avg>=50"}, 2},
+		{[]string{"MEMORY<=400000"}, 2},
+		{[]string{"CPU<=80,MEMORY<=400000"}, 3},
+	}
+
+	for _, test := range tests {
+		budgets, err := parsePerfBudgets(test.budgets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := checkPerfBudgets(tree, budgets); got != test.want {
+			t.Errorf("checkPerfBudgets(%v) = %d violations, want %d", test.budgets, got, test.want)
+		}
+	}
+}
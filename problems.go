@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"github.com/olekukonko/tablewriter"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A problemGroup is one problem of a run: a result and a message, with every place it happened
+type problemGroup struct {
+	Result    string             `json:"result"`
+	Message   string             `json:"message"`
+	Count     int                `json:"count"`
+	Devices   int                `json:"devices"`
+	Locations []*problemLocation `json:"locations"`
+}
+
+// A problemLocation is a device, suite and test where a problem happened
+type problemLocation struct {
+	Device  string `json:"device,omitempty"`
+	Os      string `json:"os,omitempty"`
+	Job     string `json:"job,omitempty"`
+	Suite   string `json:"suite,omitempty"`
+	Test    string `json:"test,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (l *problemLocation) String() string {
+
+	where := []string{}
+	if l.Device != "" {
+		where = append(where, strings.TrimSpace(l.Device+" - "+l.Os))
+	} else if l.Job != "" {
+		where = append(where, l.Job)
+	}
+	if l.Suite != "" {
+		where = append(where, l.Suite)
+	}
+	if l.Test != "" {
+		where = append(where, l.Test)
+	}
+
+	return strings.Join(where, " / ")
+}
+
+/* Fetch the unique problems of a run, by result */
+func fetchUniqueProblems(svc *devicefarm.DeviceFarm, runArn string) (map[string][]*devicefarm.UniqueProblem, error) {
+
+	problems := map[string][]*devicefarm.UniqueProblem{}
+	listReq := &devicefarm.ListUniqueProblemsInput{
+		Arn: aws.String(runArn),
+	}
+	err := svc.ListUniqueProblemsPages(listReq, func(page *devicefarm.ListUniqueProblemsOutput, lastPage bool) bool {
+		for result, unique := range page.UniqueProblems {
+			problems[result] = append(problems[result], unique...)
+		}
+		return true
+	})
+
+	return problems, err
+}
+
+func problemDetailName(detail *devicefarm.ProblemDetail) string {
+	if detail == nil {
+		return ""
+	}
+	return aws.StringValue(detail.Name)
+}
+
+// groupProblems turns the unique problems into groups of result and message,
+// problems that only differ in surrounding whitespace end up in the same group
+func groupProblems(problems map[string][]*devicefarm.UniqueProblem) []*problemGroup {
+
+	groups := []*problemGroup{}
+	byKey := map[string]*problemGroup{}
+
+	for _, result := range sortedProblemResults(problems) {
+		for _, unique := range problems[result] {
+			message := strings.TrimSpace(aws.StringValue(unique.Message))
+			key := result + "\x00" + message
+
+			group := byKey[key]
+			if group == nil {
+				group = &problemGroup{Result: result, Message: message, Locations: []*problemLocation{}}
+				byKey[key] = group
+				groups = append(groups, group)
+			}
+
+			for _, p := range unique.Problems {
+				location := &problemLocation{
+					Job:   problemDetailName(p.Job),
+					Suite: problemDetailName(p.Suite),
+					Test:  problemDetailName(p.Test),
+				}
+				if p.Device != nil {
+					location.Device = aws.StringValue(p.Device.Name)
+					location.Os = aws.StringValue(p.Device.Os)
+				}
+				// The message of a problem can tell more than the one it was grouped on
+				if m := strings.TrimSpace(aws.StringValue(p.Message)); m != message {
+					location.Message = m
+				}
+				group.Locations = append(group.Locations, location)
+			}
+		}
+	}
+
+	for _, group := range groups {
+		devices := map[string]bool{}
+		for _, location := range group.Locations {
+			devices[location.Device+"\x00"+location.Os+"\x00"+location.Job] = true
+		}
+		group.Count = len(group.Locations)
+		group.Devices = len(devices)
+	}
+
+	return groups
+}
+
+// problemRank orders the results worst first, unknown results come last
+func problemRank(result string) int {
+	for i, known := range problemResults {
+		if known == result {
+			return i
+		}
+	}
+	return len(problemResults)
+}
+
+// problemColumns are the columns problems can be sorted on
+var problemColumns = map[string]func(a *problemGroup, b *problemGroup) int{
+	"result": func(a *problemGroup, b *problemGroup) int {
+		if c := problemRank(a.Result) - problemRank(b.Result); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Result, b.Result)
+	},
+	"count":   func(a *problemGroup, b *problemGroup) int { return a.Count - b.Count },
+	"devices": func(a *problemGroup, b *problemGroup) int { return a.Devices - b.Devices },
+	"message": func(a *problemGroup, b *problemGroup) int {
+		return strings.Compare(strings.ToLower(a.Message), strings.ToLower(b.Message))
+	},
+}
+
+func problemColumnNames() []string {
+	names := []string{}
+	for name := range problemColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortProblems sorts on a list of columns, a "-" prefix sorts descending: "-count,result"
+func sortProblems(groups []*problemGroup, sortBy []string) error {
+
+	type sortKey struct {
+		compare    func(a *problemGroup, b *problemGroup) int
+		descending bool
+	}
+
+	keys := []sortKey{}
+	for _, name := range sortBy {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		descending := strings.HasPrefix(name, "-")
+		compare, ok := problemColumns[strings.ToLower(strings.TrimPrefix(name, "-"))]
+		if !ok {
+			return fmt.Errorf("unknown problem column %s, use one of [%s]", name, strings.Join(problemColumnNames(), ","))
+		}
+		keys = append(keys, sortKey{compare: compare, descending: descending})
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		for _, key := range keys {
+			if c := key.compare(groups[i], groups[j]); c != 0 {
+				return (c < 0) != key.descending
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+// problemSummary is the first line of a message, cut to fit a table
+func problemSummary(message string) string {
+
+	if message == "" {
+		return "no message"
+	}
+
+	// Cut on characters, not bytes, so the table never shows half a character
+	line := []rune(strings.TrimSpace(strings.SplitN(message, "\n", 2)[0]))
+	if len(line) > 200 {
+		return string(line[:200]) + "..."
+	}
+
+	return string(line)
+}
+
+func printProblems(groups []*problemGroup, maxLocations int) {
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(80)
+	table.SetAutoWrapText(false)
+	table.SetRowLine(true)
+	table.SetHeader([]string{"Result", "Count", "Devices", "Message", "Affected"})
+
+	for _, group := range groups {
+		affected := []string{}
+		for i, location := range group.Locations {
+			if maxLocations > 0 && i == maxLocations {
+				affected = append(affected, fmt.Sprintf("... %d more", len(group.Locations)-maxLocations))
+				break
+			}
+			affected = append(affected, location.String())
+		}
+
+		table.Append([]string{
+			group.Result,
+			fmt.Sprint(group.Count),
+			fmt.Sprint(group.Devices),
+			problemSummary(group.Message),
+			strings.Join(affected, "\n"),
+		})
+	}
+	table.Render() // Send output
+}
+
+func writeProblems(groups []*problemGroup, fileName string) error {
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if fileName == "-" {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	return ioutil.WriteFile(fileName, data, 0666)
+}
+
+// problemOptions are the settings of list problems
+type problemOptions struct {
+	From         string
+	Sort         []string
+	MaxLocations int
+	JSON         string
+}
+
+/* List the problems of a run grouped by result and message, with where they happened */
+func listProblems(svc *devicefarm.DeviceFarm, runArn string, options problemOptions) error {
+
+	var problems map[string][]*devicefarm.UniqueProblem
+	if options.From != "" {
+		tree, err := loadRunTree(options.From)
+		if err != nil {
+			return err
+		}
+		problems = tree.Problems
+	} else {
+		var err error
+		problems, err = fetchUniqueProblems(svc, runArn)
+		if err != nil {
+			return err
+		}
+	}
+
+	groups := groupProblems(problems)
+	err := sortProblems(groups, options.Sort)
+	if err != nil {
+		return err
+	}
+
+	if options.JSON == "-" {
+		return writeProblems(groups, options.JSON)
+	}
+
+	printProblems(groups, options.MaxLocations)
+
+	if options.JSON != "" {
+		err := writeProblems(groups, options.JSON)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("- [JSON] %s\n", options.JSON)
+	}
+
+	return nil
+}
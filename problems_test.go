@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func testProblem(device string, os string, test string, message string) *devicefarm.Problem {
+	return &devicefarm.Problem{
+		Device:  &devicefarm.Device{Name: aws.String(device), Os: aws.String(os)},
+		Job:     &devicefarm.ProblemDetail{Name: aws.String(device)},
+		Suite:   &devicefarm.ProblemDetail{Name: aws.String("Tests Suite")},
+		Test:    &devicefarm.ProblemDetail{Name: aws.String(test)},
+		Message: aws.String(message),
+	}
+}
+
+func TestGroupProblems(t *testing.T) {
+
+	problems := map[string][]*devicefarm.UniqueProblem{
+		"FAILED": {
+			{Message: aws.String("Timed out"), Problems: []*devicefarm.Problem{
+				testProblem("Pixel 4", "11", "login", "Timed out"),
+				testProblem("Pixel 4", "11", "logout", "Timed out after 60s"),
+			}},
+			{Message: aws.String("  Timed out\n"), Problems: []*devicefarm.Problem{
+				testProblem("Galaxy S20", "10", "login", "Timed out"),
+			}},
+		},
+		"ERRORED": {
+			{Message: aws.String("Crashed"), Problems: []*devicefarm.Problem{
+				testProblem("Galaxy S20", "10", "setup", "Crashed"),
+			}},
+		},
+		"CUSTOM": {
+			{Message: aws.String("Odd"), Problems: []*devicefarm.Problem{}},
+		},
+		"PASSED": {},
+	}
+
+	tests := []struct {
+		result    string
+		message   string
+		count     int
+		devices   int
+		locations []string
+		messages  []string
+	}{
+		{"ERRORED", "Crashed", 1, 1, []string{"Galaxy S20 - 10 / Tests Suite / setup"}, []string{""}},
+		{"FAILED", "Timed out", 3, 2, []string{
+			"Pixel 4 - 11 / Tests Suite / login",
+			"Pixel 4 - 11 / Tests Suite / logout",
+			"Galaxy S20 - 10 / Tests Suite / login",
+		}, []string{"", "Timed out after 60s", ""}},
+		{"CUSTOM", "Odd", 0, 0, []string{}, []string{}},
+	}
+
+	groups := groupProblems(problems)
+	if len(groups) != len(tests) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(tests))
+	}
+
+	for i, test := range tests {
+		group := groups[i]
+		if group.Result != test.result || group.Message != test.message || group.Count != test.count || group.Devices != test.devices {
+			t.Errorf("group %d is %s %q %d/%d, want %s %q %d/%d", i, group.Result, group.Message, group.Count, group.Devices, test.result, test.message, test.count, test.devices)
+		}
+		if len(group.Locations) != len(test.locations) {
+			t.Errorf("group %d has %d locations, want %d", i, len(group.Locations), len(test.locations))
+			continue
+		}
+		for j, location := range group.Locations {
+			if location.String() != test.locations[j] || location.Message != test.messages[j] {
+				t.Errorf("group %d location %d is %q %q, want %q %q", i, j, location.String(), location.Message, test.locations[j], test.messages[j])
+			}
+		}
+	}
+}
+
+func TestSortProblems(t *testing.T) {
+
+	groups := func() []*problemGroup {
+		return []*problemGroup{
+			{Result: "FAILED", Message: "b", Count: 2, Devices: 1},
+			{Result: "ERRORED", Message: "C", Count: 2, Devices: 2},
+			{Result: "SKIPPED", Message: "a", Count: 5, Devices: 1},
+			{Result: "CUSTOM", Message: "d", Count: 1, Devices: 1},
+			{Result: "FAILED", Message: "A", Count: 1, Devices: 3},
+		}
+	}
+
+	tests := []struct {
+		sortBy []string
+		want   []string
+		err    bool
+	}{
+		{nil, []string{"b", "C", "a", "d", "A"}, false},
+		{[]string{"result"}, []string{"C", "b", "A", "a", "d"}, false},
+		{[]string{"-count"}, []string{"a", "b", "C", "d", "A"}, false},
+		{[]string{"-count", "result"}, []string{"a", "C", "b", "A", "d"}, false},
+		{[]string{" devices ", "MESSAGE"}, []string{"a", "b", "d", "C", "A"}, false},
+		{[]string{"-devices", ""}, []string{"A", "C", "b", "a", "d"}, false},
+		{[]string{"message"}, []string{"a", "A", "b", "C", "d"}, false},
+		{[]string{"count", "severity"}, nil, true},
+	}
+
+	for _, test := range tests {
+		sorted := groups()
+		err := sortProblems(sorted, test.sortBy)
+		if (err != nil) != test.err {
+			t.Errorf("sortProblems(%v) error is %v, want an error %v", test.sortBy, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		got := []string{}
+		for _, group := range sorted {
+			got = append(got, group.Message)
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("sortProblems(%v) = %v, want %v", test.sortBy, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestProblemSummary(t *testing.T) {
+
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"", "no message"},
+		{"  Timed out  \nat com.example.Home", "Timed out"},
+		{strings.Repeat("a", 200), strings.Repeat("a", 200)},
+		{strings.Repeat("a", 201), strings.Repeat("a", 200) + "..."},
+		{strings.Repeat("é", 250), strings.Repeat("é", 200) + "..."},
+		{strings.Repeat("a", 199) + "日本語", strings.Repeat("a", 199) + "日..."},
+	}
+
+	for _, test := range tests {
+		got := problemSummary(test.message)
+		if got != test.want {
+			t.Errorf("problemSummary(%q) = %q, want %q", test.message, got, test.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("problemSummary(%q) = %q is not valid UTF-8", test.message, got)
+		}
+	}
+}
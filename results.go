@@ -0,0 +1,459 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResultMessage keeps huge stack traces out of the test messages
+const maxResultMessage = 4000
+
+type testngResults struct {
+	XMLName xml.Name      `xml:"testng-results"`
+	Suites  []testngSuite `xml:"suite"`
+}
+
+type testngSuite struct {
+	Name  string       `xml:"name,attr"`
+	Tests []testngTest `xml:"test"`
+}
+
+type testngTest struct {
+	Name    string        `xml:"name,attr"`
+	Classes []testngClass `xml:"class"`
+}
+
+type testngClass struct {
+	Name    string         `xml:"name,attr"`
+	Methods []testngMethod `xml:"test-method"`
+}
+
+type testngMethod struct {
+	Name       string           `xml:"name,attr"`
+	Status     string           `xml:"status,attr"`
+	DurationMs string           `xml:"duration-ms,attr"`
+	StartedAt  string           `xml:"started-at,attr"`
+	IsConfig   bool             `xml:"is-config,attr"`
+	Exception  *testngException `xml:"exception"`
+}
+
+type testngException struct {
+	Class      string `xml:"class,attr"`
+	Message    string `xml:"message"`
+	StackTrace string `xml:"full-stacktrace"`
+}
+
+// unzipArtifact extracts a zip into a folder
+func unzipArtifact(fileName string, dir string) error {
+
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		// Never write outside of the folder, whatever the names in the zip
+		target := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		err := unzipFile(f, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unzipFile(f *zip.File, target string) error {
+
+	err := os.MkdirAll(filepath.Dir(target), 0777)
+	if err != nil {
+		return err
+	}
+
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func truncateMessage(message string) string {
+	// Cut on characters, a cut in the middle of one is not valid XML or JSON
+	runes := []rune(strings.TrimSpace(message))
+	if len(runes) > maxResultMessage {
+		return string(runes[:maxResultMessage]) + "..."
+	}
+	return string(runes)
+}
+
+// resultTest builds a Device Farm test from a test case, timed from the start of the job
+func resultTest(name string, result string, message string, start time.Time, duration time.Duration) *testNode {
+
+	stopped := start.Add(duration)
+	return &testNode{
+		Test: &devicefarm.Test{
+			Name:    aws.String(name),
+			Result:  aws.String(result),
+			Status:  aws.String(devicefarm.ExecutionStatusCompleted),
+			Message: aws.String(truncateMessage(message)),
+			Started: aws.Time(start),
+			Stopped: aws.Time(stopped),
+		},
+		Artifacts: []*artifactNode{},
+	}
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, _ := strconv.ParseFloat(strings.Replace(value, ",", "", -1), 64)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func junitResultSuites(suite junitTestSuite, start time.Time) []*suiteNode {
+
+	suites := []*suiteNode{}
+
+	if len(suite.TestCases) > 0 {
+		node := &suiteNode{Suite: &devicefarm.Suite{Name: aws.String(suite.Name)}, Artifacts: []*artifactNode{}}
+		for _, testCase := range suite.TestCases {
+			name := testCase.Name
+			if testCase.Classname != "" && testCase.Classname != suite.Name {
+				name = testCase.Classname + "." + testCase.Name
+			}
+
+			result, message := "PASSED", ""
+			switch {
+			case testCase.Failure != nil:
+				result, message = "FAILED", testCase.Failure.Message+"\n"+testCase.Failure.Body
+			case testCase.Error != nil:
+				result, message = "ERRORED", testCase.Error.Message+"\n"+testCase.Error.Body
+			case testCase.Skipped != nil:
+				result, message = "SKIPPED", testCase.Skipped.Message
+			}
+
+			node.Tests = append(node.Tests, resultTest(name, result, message, start, parseSeconds(testCase.Time)))
+		}
+		suites = append(suites, node)
+	}
+
+	// Some tools nest their suites
+	for _, nested := range suite.Suites {
+		suites = append(suites, junitResultSuites(nested, start)...)
+	}
+
+	return suites
+}
+
+func testngResultSuites(results testngResults, start time.Time) []*suiteNode {
+
+	suites := []*suiteNode{}
+	for _, suite := range results.Suites {
+		for _, test := range suite.Tests {
+			name := test.Name
+			if suite.Name != "" && suite.Name != test.Name {
+				name = suite.Name + " - " + test.Name
+			}
+			node := &suiteNode{Suite: &devicefarm.Suite{Name: aws.String(name)}, Artifacts: []*artifactNode{}}
+
+			for _, class := range test.Classes {
+				for _, method := range class.Methods {
+					// Setup and teardown methods are not tests
+					if method.IsConfig {
+						continue
+					}
+
+					result, message := "PASSED", ""
+					switch strings.ToUpper(method.Status) {
+					case "FAIL":
+						result = "FAILED"
+					case "SKIP":
+						result = "SKIPPED"
+					}
+					if method.Exception != nil {
+						message = method.Exception.Class + ": " + strings.TrimSpace(method.Exception.Message) + "\n" + method.Exception.StackTrace
+					}
+
+					duration := time.Duration(0)
+					if ms, err := strconv.ParseInt(method.DurationMs, 10, 64); err == nil {
+						duration = time.Duration(ms) * time.Millisecond
+					}
+
+					node.Tests = append(node.Tests, resultTest(class.Name+"."+method.Name, result, message, start, duration))
+				}
+			}
+
+			if len(node.Tests) > 0 {
+				suites = append(suites, node)
+			}
+		}
+	}
+
+	return suites
+}
+
+// errNotResults is returned for XML files that are not JUnit or TestNG results
+var errNotResults = errors.New("not a JUnit or TestNG result file")
+
+/* Parse a JUnit or TestNG result file into suites */
+func parseResultFile(fileName string, start time.Time) ([]*suiteNode, error) {
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look at the root element to know the format
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	root := ""
+	for root == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, errNotResults
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			root = element.Name.Local
+		}
+	}
+
+	switch root {
+	case "testsuites":
+		results := junitTestSuites{}
+		if err := xml.Unmarshal(data, &results); err != nil {
+			return nil, err
+		}
+		suites := []*suiteNode{}
+		for _, suite := range results.Suites {
+			suites = append(suites, junitResultSuites(suite, start)...)
+		}
+		return suites, nil
+	case "testsuite":
+		suite := junitTestSuite{}
+		if err := xml.Unmarshal(data, &suite); err != nil {
+			return nil, err
+		}
+		return junitResultSuites(suite, start), nil
+	case "testng-results":
+		results := testngResults{}
+		if err := xml.Unmarshal(data, &results); err != nil {
+			return nil, err
+		}
+		return testngResultSuites(results, start), nil
+	}
+
+	return nil, errNotResults
+}
+
+// findResultFiles lists the XML files of an unpacked customer artifact
+func findResultFiles(dir string) ([]string, error) {
+
+	files := []string{}
+	err := filepath.Walk(dir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.EqualFold(filepath.Ext(fileName), ".xml") {
+			files = append(files, fileName)
+		}
+		return nil
+	})
+	sort.Strings(files)
+
+	return files, err
+}
+
+// suiteCounters counts the results of the tests of a suite
+func suiteCounters(suite *suiteNode) *devicefarm.Counters {
+
+	c := &devicefarm.Counters{}
+	add := func(counter **int64) {
+		*counter = aws.Int64(aws.Int64Value(*counter) + 1)
+	}
+
+	for _, test := range suite.Tests {
+		add(&c.Total)
+		switch aws.StringValue(test.Test.Result) {
+		case "PASSED":
+			add(&c.Passed)
+		case "FAILED":
+			add(&c.Failed)
+		case "ERRORED":
+			add(&c.Errored)
+		case "SKIPPED":
+			add(&c.Skipped)
+		case "WARNED":
+			add(&c.Warned)
+		case "STOPPED":
+			add(&c.Stopped)
+		}
+	}
+
+	return c
+}
+
+func addCounters(to **devicefarm.Counters, c *devicefarm.Counters) {
+
+	if *to == nil {
+		*to = &devicefarm.Counters{}
+	}
+	sum := func(a **int64, b *int64) {
+		*a = aws.Int64(aws.Int64Value(*a) + aws.Int64Value(b))
+	}
+
+	sum(&(*to).Total, c.Total)
+	sum(&(*to).Passed, c.Passed)
+	sum(&(*to).Failed, c.Failed)
+	sum(&(*to).Errored, c.Errored)
+	sum(&(*to).Skipped, c.Skipped)
+	sum(&(*to).Warned, c.Warned)
+	sum(&(*to).Stopped, c.Stopped)
+}
+
+// suiteResult is the worst result of the tests of a suite
+func suiteResult(c *devicefarm.Counters) string {
+	switch {
+	case aws.Int64Value(c.Errored) > 0:
+		return "ERRORED"
+	case aws.Int64Value(c.Failed) > 0:
+		return "FAILED"
+	case aws.Int64Value(c.Passed) > 0:
+		return "PASSED"
+	case aws.Int64Value(c.Skipped) > 0:
+		return "SKIPPED"
+	}
+	return "PASSED"
+}
+
+/* Merge the JUnit and TestNG results found in the customer artifacts into the run */
+func mergeCustomerResults(tree *runTree) (int, error) {
+
+	merged := 0
+	for _, job := range tree.Jobs {
+		start := aws.TimeValue(job.Job.Started)
+
+		// A run exported after merging already has these suites
+		sources := map[string]bool{}
+		for _, suite := range job.Suites {
+			if suite.Source != "" {
+				sources[suite.Source] = true
+			}
+		}
+
+		artifacts := append([]*artifactNode{}, job.Artifacts...)
+		for _, suite := range job.Suites {
+			artifacts = append(artifacts, suite.Artifacts...)
+		}
+
+		for _, artifact := range artifacts {
+			if aws.StringValue(artifact.Artifact.Type) != "CUSTOMER_ARTIFACT" || artifact.Path == "" {
+				continue
+			}
+
+			suites, err := customerArtifactResults(artifact.Path, start, sources)
+			if err != nil {
+				fmt.Fprintf(progress, "- [RESULTS] %s could not be unpacked: %s\n", artifact.Path, err)
+				continue
+			}
+
+			for _, suite := range suites {
+				suite.Suite.Counters = suiteCounters(suite)
+				suite.Suite.Result = aws.String(suiteResult(suite.Suite.Counters))
+				suite.Suite.Status = aws.String(devicefarm.ExecutionStatusCompleted)
+
+				// Devicefarm's counters already count the test that ran the framework, keep these apart
+				addCounters(&job.Merged, suite.Suite.Counters)
+				addCounters(&tree.Merged, suite.Suite.Counters)
+				job.Suites = append(job.Suites, suite)
+				sources[suite.Source] = true
+				merged += len(suite.Tests)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// customerArtifactResults unpacks a customer artifact in a temporary folder and parses the
+// result files in it. Each suite has the zip and the file in the zip as Source, sources
+// merged before are skipped.
+func customerArtifactResults(zipName string, start time.Time, sources map[string]bool) ([]*suiteNode, error) {
+
+	dir, err := ioutil.TempDir("", "devicefarm-results")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	err = unzipArtifact(zipName, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := findResultFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*suiteNode{}
+	for _, fileName := range files {
+		rel, err := filepath.Rel(dir, fileName)
+		if err != nil {
+			return nil, err
+		}
+		source := filepath.ToSlash(zipName) + "!" + filepath.ToSlash(rel)
+		if sources[source] {
+			continue
+		}
+
+		suites, err := parseResultFile(fileName, start)
+		if err == errNotResults {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(progress, "- [RESULTS] %s could not be read: %s\n", source, err)
+			continue
+		}
+
+		for _, suite := range suites {
+			suite.Source = source
+		}
+		if len(suites) > 0 {
+			fmt.Fprintf(progress, "- [RESULTS] %s\n", source)
+		}
+		results = append(results, suites...)
+	}
+
+	return results, nil
+}
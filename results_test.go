@@ -0,0 +1,250 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const junitResults = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="LoginTest" tests="3">
+    <testcase name="login" classname="LoginTest" time="1.5"/>
+    <testcase name="logout" classname="com.example.LogoutTest" time="0.5">
+      <failure message="expected true">stack</failure>
+    </testcase>
+    <testcase name="reset" classname="LoginTest">
+      <skipped/>
+    </testcase>
+    <testsuite name="Nested">
+      <testcase name="crash" time="2">
+        <error message="boom"/>
+      </testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+const testngResultsXML = `<?xml version="1.0" encoding="UTF-8"?>
+<testng-results>
+  <suite name="Suite">
+    <test name="Smoke">
+      <class name="com.example.HomeTest">
+        <test-method name="setUp" status="PASS" is-config="true"/>
+        <test-method name="opens" status="PASS" duration-ms="1200"/>
+        <test-method name="scrolls" status="FAIL" duration-ms="300">
+          <exception class="java.lang.AssertionError">
+            <message>not scrolled</message>
+            <full-stacktrace>at HomeTest.scrolls</full-stacktrace>
+          </exception>
+        </test-method>
+        <test-method name="shares" status="SKIP"/>
+      </class>
+    </test>
+  </suite>
+</testng-results>`
+
+type resultCase struct {
+	suite  string
+	test   string
+	result string
+}
+
+func TestParseResultFile(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		want    []resultCase
+		err     error
+	}{
+		{"junit.xml", junitResults, []resultCase{
+			{"LoginTest", "login", "PASSED"},
+			{"LoginTest", "com.example.LogoutTest.logout", "FAILED"},
+			{"LoginTest", "reset", "SKIPPED"},
+			{"Nested", "crash", "ERRORED"},
+		}, nil},
+		{"suite.xml", `<testsuite name="Single"><testcase name="one"/></testsuite>`, []resultCase{
+			{"Single", "one", "PASSED"},
+		}, nil},
+		{"testng-results.xml", testngResultsXML, []resultCase{
+			{"Suite - Smoke", "com.example.HomeTest.opens", "PASSED"},
+			{"Suite - Smoke", "com.example.HomeTest.scrolls", "FAILED"},
+			{"Suite - Smoke", "com.example.HomeTest.shares", "SKIPPED"},
+		}, nil},
+		{"pom.xml", `<project><modelVersion>4.0.0</modelVersion></project>`, nil, errNotResults},
+		{"empty.xml", ``, nil, errNotResults},
+	}
+
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		fileName := filepath.Join(dir, test.name)
+		writeTestFile(t, fileName, test.content)
+
+		suites, err := parseResultFile(fileName, start)
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+
+		got := []resultCase{}
+		for _, suite := range suites {
+			for _, node := range suite.Tests {
+				got = append(got, resultCase{aws.StringValue(suite.Suite.Name), aws.StringValue(node.Test.Name), aws.StringValue(node.Test.Result)})
+				if !aws.TimeValue(node.Test.Started).Equal(start) {
+					t.Errorf("%s: %s started at %s, want the start of the job", test.name, aws.StringValue(node.Test.Name), aws.TimeValue(node.Test.Started))
+				}
+			}
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: test %d is %v, want %v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestUnzipArtifactStaysInFolder(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	zipName := filepath.Join(dir, "customer.zip")
+	writeTestZip(t, zipName, [][2]string{
+		{"reports/junit.xml", "inside"},
+		{"../outside.txt", "escaped"},
+		{"reports/../../../outside-too.txt", "escaped"},
+		{"/absolute.txt", "rooted"},
+	})
+
+	target := filepath.Join(dir, "unpacked")
+	err := unzipArtifact(zipName, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+	}{
+		{filepath.Join(target, "reports", "junit.xml"), true},
+		{filepath.Join(target, "absolute.txt"), true},
+		{filepath.Join(dir, "outside.txt"), false},
+		{filepath.Join(filepath.Dir(dir), "outside-too.txt"), false},
+	}
+
+	for _, test := range tests {
+		_, err := os.Stat(test.path)
+		if exists := err == nil; exists != test.exists {
+			t.Errorf("%s exists is %v, want %v", test.path, exists, test.exists)
+		}
+	}
+}
+
+func TestMergeCustomerResults(t *testing.T) {
+
+	progress = ioutil.Discard
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	zipName := filepath.Join(dir, "Customer Artifacts.zip")
+	writeTestZip(t, zipName, [][2]string{
+		{"build/test-results/junit.xml", junitResults},
+		{"build/test-results/testng-results.xml", testngResultsXML},
+		{"build/pom.xml", "<project/>"},
+	})
+
+	counters := func(total int64, passed int64, failed int64) *devicefarm.Counters {
+		return &devicefarm.Counters{Total: aws.Int64(total), Passed: aws.Int64(passed), Failed: aws.Int64(failed)}
+	}
+
+	job := &jobNode{
+		Job: &devicefarm.Job{Name: aws.String("Pixel"), Counters: counters(1, 1, 0)},
+		Artifacts: []*artifactNode{
+			{Artifact: &devicefarm.Artifact{Type: aws.String("CUSTOMER_ARTIFACT")}, Category: "FILE", Path: zipName},
+			{Artifact: &devicefarm.Artifact{Type: aws.String("VIDEO")}, Category: "FILE", Path: filepath.Join(dir, "video.mp4")},
+		},
+	}
+	tree := &runTree{Run: &devicefarm.Run{Counters: counters(1, 1, 0)}, Jobs: []*jobNode{job}}
+
+	// Merging again, like a report from an export, adds nothing
+	for _, want := range []int{7, 0} {
+		merged, err := mergeCustomerResults(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged != want {
+			t.Errorf("merged %d tests, want %d", merged, want)
+		}
+	}
+
+	tests := []struct {
+		name string
+		got  *devicefarm.Counters
+		want *devicefarm.Counters
+	}{
+		{"run counters", tree.Run.Counters, counters(1, 1, 0)},
+		{"job counters", job.Job.Counters, counters(1, 1, 0)},
+		{"merged run counters", tree.Merged, counters(7, 2, 2)},
+		{"merged job counters", job.Merged, counters(7, 2, 2)},
+	}
+
+	for _, test := range tests {
+		if test.got == nil {
+			t.Errorf("%s missing", test.name)
+			continue
+		}
+		if aws.Int64Value(test.got.Total) != aws.Int64Value(test.want.Total) ||
+			aws.Int64Value(test.got.Passed) != aws.Int64Value(test.want.Passed) ||
+			aws.Int64Value(test.got.Failed) != aws.Int64Value(test.want.Failed) {
+			t.Errorf("%s are %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	if len(job.Suites) != 3 {
+		t.Fatalf("got %d suites, want 3", len(job.Suites))
+	}
+	for _, suite := range job.Suites {
+		if !strings.HasPrefix(suite.Source, filepath.ToSlash(zipName)+"!build/test-results/") {
+			t.Errorf("suite %s comes from %s, want a file in the zip", aws.StringValue(suite.Suite.Name), suite.Source)
+		}
+	}
+
+	// Nothing is unpacked next to the downloaded artifacts
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files next to the artifact, want only the zip", len(files))
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"  expected true\n", "expected true"},
+		{strings.Repeat("a", maxResultMessage), strings.Repeat("a", maxResultMessage)},
+		{strings.Repeat("ü", maxResultMessage+1), strings.Repeat("ü", maxResultMessage) + "..."},
+	}
+
+	for _, test := range tests {
+		if got := truncateMessage(test.message); got != test.want {
+			t.Errorf("truncateMessage(%d bytes) = %d bytes, want %d bytes", len(test.message), len(got), len(test.want))
+		}
+	}
+}
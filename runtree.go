@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// artifactCategories are the types ListArtifacts can filter on
+var artifactCategories = []string{"LOG", "SCREENSHOT", "FILE"}
+
+// runTreeVersion is bumped when the exported document changes incompatibly
+const runTreeVersion = 1
+
+// A runTree is a run with its jobs, suites, tests and artifacts
+type runTree struct {
+	Version  int                                    `json:"version"`
+	Exported time.Time                              `json:"exported"`
+	Run      *devicefarm.Run                        `json:"run"`
+	Jobs     []*jobNode                             `json:"jobs"`
+	Problems map[string][]*devicefarm.UniqueProblem `json:"problems"`
+	Crashes  []*crashGroup                          `json:"crashes,omitempty"`
+	Merged   *devicefarm.Counters                   `json:"merged,omitempty"`
+}
+
+// Artifacts of a job are the ones that do not belong to any of its suites.
+// Merged counts the tests merged from result files, devicefarm's counters do not have them.
+type jobNode struct {
+	Job       *devicefarm.Job      `json:"job"`
+	Merged    *devicefarm.Counters `json:"merged,omitempty"`
+	Suites    []*suiteNode         `json:"suites"`
+	Artifacts []*artifactNode      `json:"artifacts,omitempty"`
+	Events    []*logEvent          `json:"events,omitempty"`
+	Phases    []*specPhase         `json:"phases,omitempty"`
+	Samples   []*sampleNode        `json:"samples,omitempty"`
+	Perf      []*perfSeries        `json:"perf,omitempty"`
+}
+
+// Artifacts of a suite include the ones of its tests, tests share the same nodes.
+// Suites merged from result files in the customer artifacts have a Source.
+type suiteNode struct {
+	Suite     *devicefarm.Suite `json:"suite"`
+	Tests     []*testNode       `json:"tests"`
+	Artifacts []*artifactNode   `json:"artifacts"`
+	Source    string            `json:"source,omitempty"`
+}
+
+type testNode struct {
+	Test      *devicefarm.Test `json:"test"`
+	Artifacts []*artifactNode  `json:"artifacts"`
+}
+
+// An artifactNode is an artifact of the run, Path is set once it is downloaded
+type artifactNode struct {
+	Artifact *devicefarm.Artifact `json:"artifact"`
+	Category string               `json:"category"`
+	Path     string               `json:"path,omitempty"`
+}
+
+/* Fetch a run with all its jobs, suites, tests and artifacts */
+func crawlRun(svc *devicefarm.DeviceFarm, runArn string, concurrency int) (*runTree, error) {
+	return crawlRunJobs(svc, runArn, concurrency, crawlJob)
+}
+
+// crawlRunJobs fetches a run and its jobs, crawl fills in each job
+func crawlRunJobs(svc *devicefarm.DeviceFarm, runArn string, concurrency int, crawl func(svc *devicefarm.DeviceFarm, job *jobNode) error) (*runTree, error) {
+
+	infoReq := &devicefarm.GetRunInput{
+		Arn: aws.String(runArn),
+	}
+
+	resp, err := svc.GetRun(infoReq)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &runTree{Version: runTreeVersion, Run: resp.Run}
+
+	// Find the unique problems, by result
+	tree.Problems, err = fetchUniqueProblems(svc, runArn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find all jobs within this run
+	jobReq := &devicefarm.ListJobsInput{
+		Arn: aws.String(runArn),
+	}
+	err = svc.ListJobsPages(jobReq, func(page *devicefarm.ListJobsOutput, lastPage bool) bool {
+		for _, job := range page.Jobs {
+			tree.Jobs = append(tree.Jobs, &jobNode{Job: job})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs are crawled in parallel, each one fills in its own node
+	errs := make([]error, len(tree.Jobs))
+	forEachOrdered(len(tree.Jobs), concurrency, func(i int) {
+		errs[i] = crawl(svc, tree.Jobs[i])
+	}, nil)
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+func crawlJob(svc *devicefarm.DeviceFarm, job *jobNode) error {
+
+	artifacts, err := listArtifactNodes(svc, *job.Job.Arn, artifactFilter{})
+	if err != nil {
+		return err
+	}
+
+	err = crawlJobTests(svc, job)
+	if err != nil {
+		return err
+	}
+
+	// A job without artifacts has none in its suites either
+	if len(artifacts) == 0 {
+		return nil
+	}
+
+	owned := map[string]bool{}
+	for _, suite := range job.Suites {
+		err = crawlSuiteArtifacts(svc, suite)
+		if err != nil {
+			return err
+		}
+
+		for _, artifact := range suite.Artifacts {
+			owned[aws.StringValue(artifact.Artifact.Arn)] = true
+		}
+	}
+
+	for _, artifact := range artifacts {
+		if !owned[aws.StringValue(artifact.Artifact.Arn)] {
+			job.Artifacts = append(job.Artifacts, artifact)
+		}
+	}
+
+	return nil
+}
+
+// crawlJobTests lists the suites of a job and their tests, without artifacts
+func crawlJobTests(svc *devicefarm.DeviceFarm, job *jobNode) error {
+
+	suiteReq := &devicefarm.ListSuitesInput{
+		Arn: job.Job.Arn,
+	}
+	err := svc.ListSuitesPages(suiteReq, func(page *devicefarm.ListSuitesOutput, lastPage bool) bool {
+		for _, suite := range page.Suites {
+			job.Suites = append(job.Suites, &suiteNode{Suite: suite})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, suite := range job.Suites {
+		testReq := &devicefarm.ListTestsInput{
+			Arn: suite.Suite.Arn,
+		}
+		err := svc.ListTestsPages(testReq, func(page *devicefarm.ListTestsOutput, lastPage bool) bool {
+			for _, test := range page.Tests {
+				suite.Tests = append(suite.Tests, &testNode{Test: test})
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// crawlSuiteArtifacts lists the artifacts of a suite and of each of its tests,
+// tests are only asked for the artifact types the suite has
+func crawlSuiteArtifacts(svc *devicefarm.DeviceFarm, suite *suiteNode) error {
+
+	artifacts, err := listArtifactNodes(svc, *suite.Suite.Arn, artifactFilter{})
+	if err != nil {
+		return err
+	}
+	suite.Artifacts = artifacts
+
+	byArn := map[string]*artifactNode{}
+	types := []string{}
+	for _, artifact := range artifacts {
+		byArn[aws.StringValue(artifact.Artifact.Arn)] = artifact
+		if !containsString(types, artifact.Category) {
+			types = append(types, artifact.Category)
+		}
+	}
+
+	if len(types) == 0 {
+		return nil
+	}
+
+	for _, test := range suite.Tests {
+		artifacts, err := listArtifactNodes(svc, *test.Test.Arn, artifactFilter{Types: types})
+		if err != nil {
+			return err
+		}
+
+		for _, artifact := range artifacts {
+			arn := aws.StringValue(artifact.Artifact.Arn)
+			if shared, found := byArn[arn]; found {
+				artifact = shared
+			} else {
+				byArn[arn] = artifact
+				suite.Artifacts = append(suite.Artifacts, artifact)
+			}
+			test.Artifacts = append(test.Artifacts, artifact)
+		}
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func jobFriendlyName(job *devicefarm.Job) string {
+	if job.Device == nil {
+		return aws.StringValue(job.Name)
+	}
+	return fmt.Sprintf("%s - %s - %s", aws.StringValue(job.Name), aws.StringValue(job.Device.Model), aws.StringValue(job.Device.Os))
+}
+
+// testDuration is how long a test ran, from its timing or else from the device minutes
+func testDuration(started *time.Time, stopped *time.Time, minutes *devicefarm.DeviceMinutes) time.Duration {
+
+	if started != nil && stopped != nil && stopped.After(*started) {
+		return stopped.Sub(*started)
+	}
+
+	if minutes != nil && minutes.Total != nil {
+		return time.Duration(*minutes.Total * float64(time.Minute))
+	}
+
+	return 0
+}
+
+/* Write a run tree as a JSON document */
+func writeRunTree(tree *runTree, fileName string) error {
+
+	tree.Exported = time.Now()
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, data, 0666)
+}
+
+/* Read a run tree exported earlier */
+func loadRunTree(fileName string) (*runTree, error) {
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &runTree{}
+	err = json.Unmarshal(data, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.Version < 1 || tree.Version > runTreeVersion {
+		return nil, fmt.Errorf("%s has version %d, this version of devicefarm-cli reads version %d", fileName, tree.Version, runTreeVersion)
+	}
+
+	if tree.Run == nil {
+		return nil, errors.New(fileName + " does not contain a run")
+	}
+
+	// Tests share their artifacts with the suite, JSON made copies of them
+	for _, job := range tree.Jobs {
+		for _, suite := range job.Suites {
+			byArn := map[string]*artifactNode{}
+			for _, artifact := range suite.Artifacts {
+				byArn[aws.StringValue(artifact.Artifact.Arn)] = artifact
+			}
+			for _, test := range suite.Tests {
+				for i, artifact := range test.Artifacts {
+					if shared, found := byArn[aws.StringValue(artifact.Artifact.Arn)]; found {
+						test.Artifacts[i] = shared
+					}
+				}
+			}
+		}
+	}
+
+	return tree, nil
+}
+
+/* Export a run with all its jobs, suites, tests, problems and artifacts */
+func exportRun(svc *devicefarm.DeviceFarm, runArn string, fileName string, concurrency int) error {
+
+	tree, err := crawlRun(svc, runArn, concurrency)
+	failOnErr(err, "error getting run info")
+
+	err = writeRunTree(tree, fileName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("- Exported run %s to %s\n", aws.StringValue(tree.Run.Name), fileName)
+	return nil
+}
+
+// runArtifacts lists all artifacts of the run, in report order
+func runArtifacts(tree *runTree) []*artifactNode {
+
+	artifacts := []*artifactNode{}
+	for _, job := range tree.Jobs {
+		for _, artifactType := range artifactCategories {
+			for _, artifact := range job.Artifacts {
+				if artifact.Category == artifactType {
+					artifacts = append(artifacts, artifact)
+				}
+			}
+		}
+		for _, suite := range job.Suites {
+			for _, artifactType := range artifactCategories {
+				for _, artifact := range suite.Artifacts {
+					if artifact.Category == artifactType {
+						artifacts = append(artifacts, artifact)
+					}
+				}
+			}
+		}
+	}
+
+	return artifacts
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"github.com/olekukonko/tablewriter"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Results of comparing a screenshot with its baseline
+const (
+	screenshotSame    = "SAME"
+	screenshotChanged = "CHANGED"
+	screenshotSize    = "SIZE_CHANGED"
+	screenshotAdded   = "ADDED"
+	screenshotRemoved = "REMOVED"
+)
+
+// A screenshot is a downloaded screenshot, Key pairs it with the same screenshot of another run
+type screenshot struct {
+	Key    string
+	Device string
+	Name   string
+	Path   string
+}
+
+// A screenshotDiff is the outcome of comparing two screenshots
+type screenshotDiff struct {
+	Device     string  `json:"device"`
+	Name       string  `json:"name"`
+	Result     string  `json:"result"`
+	Pixel      float64 `json:"pixel"`
+	Perceptual float64 `json:"perceptual"`
+	Baseline   string  `json:"baseline,omitempty"`
+	Current    string  `json:"current,omitempty"`
+	Diff       string  `json:"diff,omitempty"`
+}
+
+// screenshotOptions are the settings of screenshots diff
+type screenshotOptions struct {
+	OutDir         string
+	Threshold      float64
+	PixelTolerance float64
+	Metric         string
+	AllowRemoved   bool
+	Concurrency    int
+	JSON           string
+}
+
+func isImageFile(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// keyScreenshots gives every screenshot a key of its device and name,
+// a name seen twice on a device gets a number in order of the paths
+func keyScreenshots(shots []*screenshot) map[string]*screenshot {
+
+	sort.SliceStable(shots, func(i, j int) bool {
+		return shots[i].Path < shots[j].Path
+	})
+
+	keyed := map[string]*screenshot{}
+	for _, shot := range shots {
+		name := shot.Name
+		for i := 2; keyed[strings.ToLower(shot.Device+"/"+name)] != nil; i++ {
+			name = fmt.Sprintf("%s (%d)", shot.Name, i)
+		}
+		shot.Name = name
+		shot.Key = strings.ToLower(shot.Device + "/" + name)
+		keyed[shot.Key] = shot
+	}
+
+	return keyed
+}
+
+// manifestScreenshots reads the screenshots listed in the manifest.json of a report folder
+func manifestScreenshots(dir string) ([]*screenshot, error) {
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := manifest{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	shots := []*screenshot{}
+	for _, entry := range doc.Artifacts {
+		if entry.Category != "SCREENSHOT" {
+			continue
+		}
+		device := entry.Device
+		if device == "" {
+			device = entry.Job
+		}
+		shots = append(shots, &screenshot{Device: device, Name: entry.Name, Path: filepath.Join(dir, filepath.FromSlash(entry.Path))})
+	}
+
+	return shots, nil
+}
+
+// folderScreenshots pairs the images of a folder without manifest by their relative path
+func folderScreenshots(dir string) ([]*screenshot, error) {
+
+	shots := []*screenshot{}
+	err := filepath.Walk(dir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !isImageFile(fileName) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fileName)
+		if err != nil {
+			return err
+		}
+		rel = strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+		shots = append(shots, &screenshot{Device: filepath.ToSlash(filepath.Dir(rel)), Name: filepath.Base(rel), Path: fileName})
+		return nil
+	})
+
+	return shots, err
+}
+
+// downloadRunScreenshots downloads the screenshots of a run to dir in the report layout,
+// with a manifest so the folder can be used as a baseline later on
+func downloadRunScreenshots(svc *devicefarm.DeviceFarm, runArn string, dir string, concurrency int) error {
+
+	tree, err := crawlRun(svc, runArn, concurrency)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("- Downloading the screenshots of run %s to %s\n", aws.StringValue(tree.Run.Name), dir)
+
+	assignArtifactPaths(tree, dir, defaultReportLayout)
+
+	artifacts := []*artifactNode{}
+	for _, artifact := range runArtifacts(tree) {
+		if artifact.Category == "SCREENSHOT" {
+			artifacts = append(artifacts, artifact)
+		} else {
+			artifact.Path = ""
+		}
+	}
+
+	d := newDownloader(svc)
+	errs := make([]error, len(artifacts))
+	forEachOrdered(len(artifacts), concurrency, func(i int) {
+		errs[i] = d.downloadArtifact(artifacts[i].Path, artifacts[i].Artifact)
+	}, func(i int) {
+		if errs[i] != nil {
+			fmt.Printf("- [SCREENSHOT] %s failed: %s\n", artifacts[i].Path, errs[i])
+			artifacts[i].Path = ""
+		}
+	})
+	d.printSummary()
+
+	_, err = writeManifest(dir, manifestFromTree(tree))
+	return err
+}
+
+/* Find the screenshots of a report folder, or download the ones of a run to downloadDir */
+func loadScreenshots(svc *devicefarm.DeviceFarm, projectName string, source string, downloadDir string, concurrency int) (map[string]*screenshot, error) {
+
+	dir := source
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		runArn, err := lookupRunArn(svc, projectName, source)
+		if err != nil {
+			return nil, err
+		}
+		dir = downloadDir
+		err = downloadRunScreenshots(svc, runArn, dir, concurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	shots, err := manifestScreenshots(dir)
+	if os.IsNotExist(err) {
+		shots, err = folderScreenshots(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return keyScreenshots(shots), nil
+}
+
+func loadImage(fileName string) (image.Image, error) {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// channelDelta is the largest difference of the channels of two colors, from 0 to 1
+func channelDelta(a color.Color, b color.Color) float64 {
+
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+
+	delta := uint32(0)
+	for _, pair := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+		d := pair[0] - pair[1]
+		if pair[1] > pair[0] {
+			d = pair[1] - pair[0]
+		}
+		if d > delta {
+			delta = d
+		}
+	}
+
+	return float64(delta) / 0xffff
+}
+
+// pixelDiff is the share of pixels that differ more than the tolerance, with
+// an image of the current screenshot greyed out and the changed pixels in red
+func pixelDiff(baseline image.Image, current image.Image, tolerance float64) (float64, *image.RGBA) {
+
+	bounds := current.Bounds()
+	diff := image.NewRGBA(bounds)
+	offset := baseline.Bounds().Min.Sub(bounds.Min)
+	changed := 0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := current.At(x, y)
+			if channelDelta(baseline.At(x+offset.X, y+offset.Y), c) > tolerance {
+				changed++
+				diff.Set(x, y, color.RGBA{R: 255, A: 255})
+				continue
+			}
+			grey := color.GrayModel.Convert(c).(color.Gray)
+			grey.Y = 128 + grey.Y/2
+			diff.Set(x, y, grey)
+		}
+	}
+
+	total := bounds.Dx() * bounds.Dy()
+	if total == 0 {
+		return 0, diff
+	}
+
+	return float64(changed) / float64(total), diff
+}
+
+// differenceHash shrinks an image to 9x8 grey pixels and compares each one with its right neighbour
+func differenceHash(img image.Image) uint64 {
+
+	bounds := img.Bounds()
+	grey := func(x int, y int) uint8 {
+		// Average the block of pixels that becomes one pixel of the small image
+		x0 := bounds.Min.X + x*bounds.Dx()/9
+		x1 := bounds.Min.X + (x+1)*bounds.Dx()/9
+		y0 := bounds.Min.Y + y*bounds.Dy()/8
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/8
+		sum, count := 0, 0
+		for py := y0; py < y1 || py == y0; py++ {
+			for px := x0; px < x1 || px == x0; px++ {
+				sum += int(color.GrayModel.Convert(img.At(px, py)).(color.Gray).Y)
+				count++
+			}
+		}
+		return uint8(sum / count)
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grey(x, y) > grey(x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// perceptualDiff is the share of the difference hash bits that differ, from 0 to 1
+func perceptualDiff(baseline image.Image, current image.Image) float64 {
+	return float64(bits.OnesCount64(differenceHash(baseline)^differenceHash(current))) / 64
+}
+
+func writePNG(img image.Image, fileName string) error {
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = png.Encode(file, img)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func compareScreenshots(baseline *screenshot, current *screenshot, options screenshotOptions) (*screenshotDiff, error) {
+
+	result := &screenshotDiff{Device: current.Device, Name: current.Name, Baseline: baseline.Path, Current: current.Path}
+
+	baseImage, err := loadImage(baseline.Path)
+	if err != nil {
+		return nil, err
+	}
+	currentImage, err := loadImage(current.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Perceptual = perceptualDiff(baseImage, currentImage)
+
+	if baseImage.Bounds().Size() != currentImage.Bounds().Size() {
+		result.Result = screenshotSize
+		result.Pixel = 1
+		return result, nil
+	}
+
+	var diff *image.RGBA
+	result.Pixel, diff = pixelDiff(baseImage, currentImage, options.PixelTolerance)
+
+	result.Result = screenshotSame
+	if result.Pixel > 0 {
+		result.Result = screenshotChanged
+		result.Diff = filepath.Join(options.OutDir, "diff", renderLayout("{device}/{name}.{ext}", artifactFields{Device: current.Device, Name: current.Name, Ext: "png"}))
+		err := writePNG(diff, result.Diff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// failed tells if a difference is above the threshold for the chosen metric,
+// a screenshot that disappeared fails unless removed ones are allowed
+func (d *screenshotDiff) failed(options screenshotOptions) bool {
+	switch d.Result {
+	case screenshotSize:
+		return true
+	case screenshotRemoved:
+		return !options.AllowRemoved
+	case screenshotChanged:
+		if options.Metric == "perceptual" {
+			return d.Perceptual > options.Threshold
+		}
+		return d.Pixel > options.Threshold
+	}
+	return false
+}
+
+/* Compare the screenshots of a run with a baseline run or folder */
+func diffScreenshots(svc *devicefarm.DeviceFarm, projectName string, baselineSource string, currentSource string, options screenshotOptions) error {
+
+	if options.Metric != "pixel" && options.Metric != "perceptual" {
+		return errors.New("metric should be pixel or perceptual, not " + options.Metric)
+	}
+
+	// Runs go to their own folder, two runs may have the same name
+	baseline, err := loadScreenshots(svc, projectName, baselineSource, filepath.Join(options.OutDir, "baseline"), options.Concurrency)
+	if err != nil {
+		return err
+	}
+	current, err := loadScreenshots(svc, projectName, currentSource, filepath.Join(options.OutDir, "current"), options.Concurrency)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to compare usually means a wrong folder or a run without screenshots
+	if len(baseline) == 0 {
+		return fmt.Errorf("no screenshots found in the baseline %s", baselineSource)
+	}
+	if len(current) == 0 {
+		return fmt.Errorf("no screenshots found in %s", currentSource)
+	}
+
+	keys := []string{}
+	for key := range current {
+		keys = append(keys, key)
+	}
+	for key := range baseline {
+		if current[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diffs := make([]*screenshotDiff, len(keys))
+	errs := make([]error, len(keys))
+	forEachOrdered(len(keys), options.Concurrency, func(i int) {
+		base, cur := baseline[keys[i]], current[keys[i]]
+		switch {
+		case base == nil:
+			diffs[i] = &screenshotDiff{Device: cur.Device, Name: cur.Name, Result: screenshotAdded, Current: cur.Path}
+		case cur == nil:
+			diffs[i] = &screenshotDiff{Device: base.Device, Name: base.Name, Result: screenshotRemoved, Baseline: base.Path}
+		default:
+			diffs[i], errs[i] = compareScreenshots(base, cur, options)
+		}
+	}, nil)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColWidth(80)
+	table.SetHeader([]string{"Device", "Screenshot", "Result", "Pixel", "Perceptual", "Diff"})
+
+	failed := 0
+	results := []*screenshotDiff{}
+	for i, key := range keys {
+		if errs[i] != nil {
+			return fmt.Errorf("error comparing %s: %s", key, errs[i])
+		}
+		d := diffs[i]
+		results = append(results, d)
+
+		result := d.Result
+		if d.failed(options) {
+			result += " (FAILED)"
+			failed++
+		}
+		pixel, perceptual := "", ""
+		if d.Baseline != "" && d.Current != "" {
+			pixel = fmt.Sprintf("%.2f%%", d.Pixel*100)
+			perceptual = fmt.Sprintf("%.2f%%", d.Perceptual*100)
+		}
+		table.Append([]string{d.Device, d.Name, result, pixel, perceptual, d.Diff})
+	}
+	table.Render() // Send output
+
+	if options.JSON != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(options.JSON, data, 0666)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("- [JSON] %s\n", options.JSON)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d screenshots differ more than %.2f%% from the baseline, changed size or were removed", failed, options.Threshold*100)
+	}
+
+	return nil
+}
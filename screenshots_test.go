@@ -0,0 +1,172 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage is a horizontal gradient, with the given rectangle painted white
+func testImage(width int, height int, white image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: uint8(x * 255 / width), G: uint8(x * 255 / width), B: 64, A: 255}
+			if image.Pt(x, y).In(white) {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestPixelDiff(t *testing.T) {
+
+	base := testImage(10, 10, image.Rectangle{})
+
+	slight := testImage(10, 10, image.Rectangle{})
+	slight.Set(0, 0, color.RGBA{R: 10, G: 0, B: 64, A: 255})
+
+	tests := []struct {
+		name      string
+		current   image.Image
+		tolerance float64
+		want      float64
+	}{
+		{"same", testImage(10, 10, image.Rectangle{}), 0.1, 0},
+		{"one pixel", testImage(10, 10, image.Rect(0, 0, 1, 1)), 0.1, 0.01},
+		{"a quarter", testImage(10, 10, image.Rect(0, 0, 5, 5)), 0.1, 0.25},
+		{"within tolerance", slight, 0.1, 0},
+		{"without tolerance", slight, 0, 0.01},
+	}
+
+	for _, test := range tests {
+		got, diff := pixelDiff(base, test.current, test.tolerance)
+		if got != test.want {
+			t.Errorf("%s: pixel difference is %v, want %v", test.name, got, test.want)
+		}
+		if diff.Bounds() != test.current.Bounds() {
+			t.Errorf("%s: diff image is %v, want %v", test.name, diff.Bounds(), test.current.Bounds())
+		}
+		if test.want > 0 && diff.RGBAAt(0, 0) != (color.RGBA{R: 255, A: 255}) {
+			t.Errorf("%s: changed pixel is %v in the diff image, want red", test.name, diff.RGBAAt(0, 0))
+		}
+	}
+
+	// Images that do not start at the origin are compared pixel for pixel
+	shifted := testImage(10, 10, image.Rect(0, 0, 5, 5)).SubImage(image.Rect(5, 5, 10, 10))
+	if got, _ := pixelDiff(base.SubImage(image.Rect(5, 5, 10, 10)), shifted, 0.1); got != 0 {
+		t.Errorf("sub images: pixel difference is %v, want 0", got)
+	}
+}
+
+func TestDifferenceHash(t *testing.T) {
+
+	base := testImage(90, 80, image.Rectangle{})
+
+	tests := []struct {
+		name    string
+		other   image.Image
+		maxBits int
+		minBits int
+	}{
+		{"same", testImage(90, 80, image.Rectangle{}), 0, 0},
+		{"scaled", testImage(180, 160, image.Rectangle{}), 0, 0},
+		{"tiny", testImage(5, 4, image.Rectangle{}), 64, 0},
+		{"white first column", testImage(90, 80, image.Rect(0, 0, 10, 80)), 8, 8},
+	}
+
+	for _, test := range tests {
+		got := bits.OnesCount64(differenceHash(base) ^ differenceHash(test.other))
+		if got > test.maxBits || got < test.minBits {
+			t.Errorf("%s: %d bits differ, want %d to %d", test.name, got, test.minBits, test.maxBits)
+		}
+	}
+
+	// The gradient gets darker to the left on every row
+	if hash := differenceHash(base); hash != 0 {
+		t.Errorf("gradient hash is %x, want 0", hash)
+	}
+	if hash := differenceHash(testImage(90, 80, image.Rect(0, 0, 10, 80))); hash>>56 != 0x80 {
+		t.Errorf("hash of a white first column is %x, want its first bit set on every row", hash)
+	}
+}
+
+func TestScreenshotDiffFailed(t *testing.T) {
+
+	pixel := screenshotOptions{Metric: "pixel", Threshold: 0.01}
+	perceptual := screenshotOptions{Metric: "perceptual", Threshold: 0.1}
+	allowRemoved := screenshotOptions{Metric: "pixel", Threshold: 0.01, AllowRemoved: true}
+
+	tests := []struct {
+		diff    screenshotDiff
+		options screenshotOptions
+		want    bool
+	}{
+		{screenshotDiff{Result: screenshotSame}, pixel, false},
+		{screenshotDiff{Result: screenshotChanged, Pixel: 0.005, Perceptual: 0.5}, pixel, false},
+		{screenshotDiff{Result: screenshotChanged, Pixel: 0.02, Perceptual: 0}, pixel, true},
+		{screenshotDiff{Result: screenshotChanged, Pixel: 0.02, Perceptual: 0.05}, perceptual, false},
+		{screenshotDiff{Result: screenshotChanged, Pixel: 0, Perceptual: 0.2}, perceptual, true},
+		{screenshotDiff{Result: screenshotSize}, pixel, true},
+		{screenshotDiff{Result: screenshotAdded}, pixel, false},
+		{screenshotDiff{Result: screenshotRemoved}, pixel, true},
+		{screenshotDiff{Result: screenshotRemoved}, allowRemoved, false},
+	}
+
+	for _, test := range tests {
+		if got := test.diff.failed(test.options); got != test.want {
+			t.Errorf("%+v failed with %+v is %v, want %v", test.diff, test.options, got, test.want)
+		}
+	}
+}
+
+func TestDiffScreenshotFolders(t *testing.T) {
+
+	dir := tempTestDir(t)
+	defer os.RemoveAll(dir)
+
+	plain := testImage(10, 10, image.Rectangle{})
+	for _, fileName := range []string{"baseline/Pixel 4/login.png", "baseline/Pixel 4/logout.png", "current/Pixel 4/login.png"} {
+		if err := writePNG(plain, filepath.Join(dir, filepath.FromSlash(fileName))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "empty"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		baseline     string
+		current      string
+		allowRemoved bool
+		err          bool
+	}{
+		{"same", "current", "current", false, false},
+		{"added", "current", "baseline", false, false},
+		{"removed", "baseline", "current", false, true},
+		{"removed allowed", "baseline", "current", true, false},
+		{"empty baseline", "empty", "current", false, true},
+		{"empty current", "baseline", "empty", true, true},
+	}
+
+	for _, test := range tests {
+		options := screenshotOptions{
+			OutDir:         filepath.Join(dir, "out"),
+			Threshold:      0.01,
+			PixelTolerance: 0.1,
+			Metric:         "pixel",
+			AllowRemoved:   test.allowRemoved,
+			Concurrency:    2,
+		}
+		err := diffScreenshots(nil, "", filepath.Join(dir, test.baseline), filepath.Join(dir, test.current), options)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want an error %v", test.name, err, test.err)
+		}
+	}
+}
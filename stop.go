@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/devicefarm"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/* Find a project by Arn or name */
+func lookupProjectArn(svc *devicefarm.DeviceFarm, projectName string) (string, error) {
+
+	if projectName == "" || isArn(projectName) {
+		return projectName, nil
+	}
+
+	projectArn := ""
+	err := svc.ListProjectsPages(&devicefarm.ListProjectsInput{}, func(page *devicefarm.ListProjectsOutput, lastPage bool) bool {
+		for _, m := range page.Projects {
+			if aws.StringValue(m.Name) == projectName {
+				projectArn = aws.StringValue(m.Arn)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if projectArn == "" {
+		return "", errors.New("failed to find a project with name " + projectName)
+	}
+
+	return projectArn, nil
+}
+
+/* Find a run by Arn or name, the most recent run wins when names are reused */
+func lookupRunArn(svc *devicefarm.DeviceFarm, projectName string, runName string) (string, error) {
+
+	if runName == "" || isArn(runName) {
+		return runName, nil
+	}
+
+	if projectName == "" {
+		return "", errors.New("we need a project to find the run " + runName)
+	}
+
+	projectArn, err := lookupProjectArn(svc, projectName)
+	if err != nil {
+		return "", err
+	}
+
+	runs := []*devicefarm.Run{}
+	listReq := &devicefarm.ListRunsInput{
+		Arn: aws.String(projectArn),
+	}
+	err = svc.ListRunsPages(listReq, func(page *devicefarm.ListRunsOutput, lastPage bool) bool {
+		for _, m := range page.Runs {
+			if aws.StringValue(m.Name) == runName {
+				runs = append(runs, m)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(runs) == 0 {
+		return "", errors.New("failed to find a run with name " + runName)
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return aws.TimeValue(runs[i].Created).After(aws.TimeValue(runs[j].Created))
+	})
+
+	return aws.StringValue(runs[0].Arn), nil
+}
+
+/* Find a job of a run by Arn, job name or device name */
+func lookupJobArn(svc *devicefarm.DeviceFarm, runArn string, jobName string) (string, error) {
+
+	if jobName == "" || isArn(jobName) {
+		return jobName, nil
+	}
+
+	if runArn == "" {
+		return "", errors.New("we need a run to find the job " + jobName)
+	}
+
+	candidates := []deviceCandidate{}
+	jobs := map[*devicefarm.Device]*devicefarm.Job{}
+	listReq := &devicefarm.ListJobsInput{
+		Arn: aws.String(runArn),
+	}
+	err := svc.ListJobsPages(listReq, func(page *devicefarm.ListJobsOutput, lastPage bool) bool {
+		for _, m := range page.Jobs {
+			device := m.Device
+			if device == nil {
+				device = &devicefarm.Device{Name: m.Name}
+			}
+
+			score := scoreDevice(device, jobName)
+			if aws.StringValue(m.Name) == jobName {
+				score = 100
+			}
+
+			if score > 0 {
+				jobs[device] = m
+				candidates = append(candidates, deviceCandidate{Device: device, Score: score})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(candidates) == 0 {
+		return "", errors.New("failed to find a job with name " + jobName)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	if len(candidates) > 1 && candidates[0].Score == candidates[1].Score {
+		return "", fmt.Errorf("more than one job matches %s, use the job Arn", jobName)
+	}
+
+	return aws.StringValue(jobs[candidates[0].Device].Arn), nil
+}
+
+/* Find a remote access session by Arn or name */
+func lookupSessionArn(svc *devicefarm.DeviceFarm, projectName string, sessionName string) (string, error) {
+
+	if sessionName == "" || isArn(sessionName) {
+		return sessionName, nil
+	}
+
+	if projectName == "" {
+		return "", errors.New("we need a project to find the session " + sessionName)
+	}
+
+	projectArn, err := lookupProjectArn(svc, projectName)
+	if err != nil {
+		return "", err
+	}
+
+	listReq := &devicefarm.ListRemoteAccessSessionsInput{
+		Arn: aws.String(projectArn),
+	}
+
+	for {
+		resp, err := svc.ListRemoteAccessSessions(listReq)
+		if err != nil {
+			return "", err
+		}
+
+		for _, m := range resp.RemoteAccessSessions {
+			if aws.StringValue(m.Name) == sessionName && aws.StringValue(m.Status) != "COMPLETED" {
+				return aws.StringValue(m.Arn), nil
+			}
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		listReq.NextToken = resp.NextToken
+	}
+
+	return "", errors.New("failed to find an active session with name " + sessionName)
+}
+
+/* Stop a run */
+func stopRun(svc *devicefarm.DeviceFarm, runArn string) error {
+
+	if runArn == "" {
+		return errors.New("we need a run to stop")
+	}
+
+	resp, err := svc.StopRun(&devicefarm.StopRunInput{
+		Arn: aws.String(runArn),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "- Stopping run %s [%s]\n", aws.StringValue(resp.Run.Name), aws.StringValue(resp.Run.Status))
+	return nil
+}
+
+/* Stop a job */
+func stopJob(svc *devicefarm.DeviceFarm, jobArn string) error {
+
+	if jobArn == "" {
+		return errors.New("we need a job to stop")
+	}
+
+	resp, err := svc.StopJob(&devicefarm.StopJobInput{
+		Arn: aws.String(jobArn),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "- Stopping job %s [%s]\n", aws.StringValue(resp.Job.Name), aws.StringValue(resp.Job.Status))
+	return nil
+}
+
+/* Stop a remote access session */
+func stopSession(svc *devicefarm.DeviceFarm, sessionArn string) error {
+
+	if sessionArn == "" {
+		return errors.New("we need a session to stop")
+	}
+
+	resp, err := svc.StopRemoteAccessSession(&devicefarm.StopRemoteAccessSessionInput{
+		Arn: aws.String(sessionArn),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(progress, "- Stopping session %s [%s]\n", aws.StringValue(resp.RemoteAccessSession.Name), aws.StringValue(resp.RemoteAccessSession.Status))
+	return nil
+}
+
+/* Wait for a run to complete, offering to stop it on Ctrl-C */
+func waitForRun(svc *devicefarm.DeviceFarm, runArn string, stopOnInterrupt bool) error {
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	status := ""
+	for status != "COMPLETED" {
+		select {
+		case <-interrupts:
+			// A second Ctrl-C while asking exits right away
+			signal.Stop(interrupts)
+
+			if !stopOnInterrupt && !confirm("\n- Interrupted, stop the run on devicefarm? [y/N] ") {
+				return fmt.Errorf("stopped waiting, run %s keeps running on devicefarm", runArn)
+			}
+
+			err := stopRun(svc, runArn)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("run %s was stopped", runArn)
+		case <-time.After(4 * time.Second):
+		}
+
+		infoReq := &devicefarm.GetRunInput{
+			Arn: aws.String(runArn),
+		}
+
+		fmt.Fprint(progress, ".")
+		resp, err := svc.GetRun(infoReq)
+
+		if err != nil {
+			return err
+		}
+		status = *resp.Run.Status
+	}
+
+	return nil
+}
+
+func confirm(question string) bool {
+
+	fmt.Fprint(progress, question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// specOutputTail is how many output lines are kept per command
+const specOutputTail = 20
+
+// A specPhase is one phase of a test spec (install, pre_test, test, post_test) as it ran on a device
+type specPhase struct {
+	Name     string         `json:"name"`
+	ExitCode *int           `json:"exitCode,omitempty"`
+	Duration time.Duration  `json:"duration"`
+	Commands []*specCommand `json:"commands"`
+}
+
+// A specCommand is a command of a phase with the tail of its output
+type specCommand struct {
+	Command  string        `json:"command"`
+	ExitCode *int          `json:"exitCode,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Line     int           `json:"line"`
+	Output   []string      `json:"output,omitempty"`
+
+	started time.Time
+}
+
+var (
+	// [DeviceFarm] npm install
+	specCommandLine = regexp.MustCompile(`(?i)^\[devicefarm\]\s?(.*)$`)
+	specPhaseStart  = regexp.MustCompile(`(?i)entering (?:phase )?\[?(\w+)\]? phase|entering phase \[?(\w+)\]?`)
+	specPhaseEnd    = regexp.MustCompile(`(?i)(?:exiting|finished|finish executing|completed) (?:phase )?\[?(\w+)\]? phase|(?:exiting|finished|finish executing|completed) phase \[?(\w+)\]?`)
+	specExitCode    = regexp.MustCompile(`(?i)exit(?:ed with)? (?:code|status)\W*(-?\d+)`)
+	specTimestamp   = regexp.MustCompile(`^\[?(\d{4}-\d\d-\d\d[T ]\d\d:\d\d:\d\d(?:\.\d+)?(?:Z|[+-]\d\d:?\d\d)?)\]?\s*`)
+)
+
+var specTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"}
+
+// specLineTime strips a leading timestamp from a line, when it has one
+func specLineTime(line string) (time.Time, string) {
+
+	m := specTimestamp.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, line
+	}
+
+	for _, layout := range specTimeLayouts {
+		if t, err := time.Parse(layout, m[1]); err == nil {
+			return t, line[len(m[0]):]
+		}
+	}
+
+	return time.Time{}, line
+}
+
+func firstMatch(m []string) string {
+	for _, group := range m[1:] {
+		if group != "" {
+			return strings.ToLower(group)
+		}
+	}
+	return ""
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+// failed is the first command of a phase that exited with an error; when the
+// phase failed without telling which command, its last command is blamed
+func (p *specPhase) failed() *specCommand {
+
+	for _, command := range p.Commands {
+		if command.ExitCode != nil && *command.ExitCode != 0 {
+			return command
+		}
+	}
+
+	if p.ExitCode != nil && *p.ExitCode != 0 && len(p.Commands) > 0 {
+		return p.Commands[len(p.Commands)-1]
+	}
+
+	return nil
+}
+
+/* Parse the output of a test spec in its phases and commands */
+func parseTestSpecOutput(r io.Reader) ([]*specPhase, error) {
+
+	phases := []*specPhase{}
+	var phase *specPhase
+	var command *specCommand
+	var phaseStarted, last time.Time
+
+	finishCommand := func(at time.Time) {
+		if command != nil && !command.started.IsZero() && !at.IsZero() && at.After(command.started) {
+			command.Duration = at.Sub(command.started)
+		}
+		command = nil
+	}
+
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			lineNumber++
+			at, text := specLineTime(strings.TrimRight(line, "\r\n"))
+			if !at.IsZero() {
+				last = at
+			}
+
+			if m := specPhaseStart.FindStringSubmatch(text); m != nil {
+				finishCommand(at)
+				phase = &specPhase{Name: firstMatch(m), Commands: []*specCommand{}}
+				phases = append(phases, phase)
+				phaseStarted = at
+			} else if m := specPhaseEnd.FindStringSubmatch(text); m != nil && phase != nil {
+				finishCommand(at)
+				if code := specExitCode.FindStringSubmatch(text); code != nil {
+					exitCode, _ := strconv.Atoi(code[1])
+					phase.ExitCode = intPointer(exitCode)
+				}
+				if !phaseStarted.IsZero() && at.After(phaseStarted) {
+					phase.Duration = at.Sub(phaseStarted)
+				}
+				phase = nil
+			} else if m := specCommandLine.FindStringSubmatch(text); m != nil && phase != nil {
+				if code := specExitCode.FindStringSubmatch(m[1]); code != nil {
+					// Device Farm tells when a command failed on a line of its own
+					exitCode, _ := strconv.Atoi(code[1])
+					target := command
+					if target == nil && len(phase.Commands) > 0 {
+						target = phase.Commands[len(phase.Commands)-1]
+					}
+					if target != nil {
+						target.ExitCode = intPointer(exitCode)
+					} else {
+						phase.ExitCode = intPointer(exitCode)
+					}
+				} else {
+					finishCommand(at)
+					command = &specCommand{Command: strings.TrimSpace(m[1]), Line: lineNumber, started: at}
+					phase.Commands = append(phase.Commands, command)
+				}
+			} else if command != nil {
+				command.Output = append(command.Output, text)
+				if len(command.Output) > specOutputTail {
+					command.Output = command.Output[1:]
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	finishCommand(last)
+	if phase != nil && !phaseStarted.IsZero() && last.After(phaseStarted) {
+		phase.Duration = last.Sub(phaseStarted)
+	}
+
+	return phases, nil
+}
+
+func parseTestSpecFile(fileName string) ([]*specPhase, error) {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseTestSpecOutput(file)
+}
+
+/* Parse the downloaded test spec output of every job of a run */
+func analyzeTestSpecs(tree *runTree) error {
+
+	for _, job := range tree.Jobs {
+		job.Phases = nil
+
+		artifacts := append([]*artifactNode{}, job.Artifacts...)
+		for _, suite := range job.Suites {
+			artifacts = append(artifacts, suite.Artifacts...)
+		}
+
+		for _, artifact := range artifacts {
+			if aws.StringValue(artifact.Artifact.Type) != "TESTSPEC_OUTPUT" || artifact.Path == "" {
+				continue
+			}
+			phases, err := parseTestSpecFile(artifact.Path)
+			if err != nil {
+				return err
+			}
+			job.Phases = append(job.Phases, phases...)
+		}
+	}
+
+	return nil
+}
+
+// firstFailure is the phase and command where the test spec of a job first failed
+func firstFailure(job *jobNode) (*specPhase, *specCommand) {
+	for _, phase := range job.Phases {
+		if command := phase.failed(); command != nil {
+			return phase, command
+		}
+		if phase.ExitCode != nil && *phase.ExitCode != 0 {
+			return phase, nil
+		}
+	}
+	return nil, nil
+}
+
+// describeFailure is a one line description of where a test spec failed
+func describeFailure(phase *specPhase, command *specCommand) string {
+
+	if command == nil {
+		return fmt.Sprintf("phase %s failed with exit code %d", phase.Name, *phase.ExitCode)
+	}
+
+	exitCode := phase.ExitCode
+	if command.ExitCode != nil {
+		exitCode = command.ExitCode
+	}
+	if exitCode == nil {
+		return fmt.Sprintf("phase %s failed at `%s`", phase.Name, command.Command)
+	}
+
+	return fmt.Sprintf("phase %s failed at `%s` with exit code %d", phase.Name, command.Command, *exitCode)
+}
+
+func printTestSpecFailures(tree *runTree) {
+	for _, job := range tree.Jobs {
+		if phase, command := firstFailure(job); phase != nil {
+			fmt.Fprintf(progress, "- [TESTSPEC] %s: %s\n", jobFriendlyName(job.Job), describeFailure(phase, command))
+		}
+	}
+}
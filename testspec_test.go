@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSpecOutput = `2020-10-01 12:00:00.000 Entering [install] phase
+2020-10-01 12:00:00.000 [DeviceFarm] npm install
+added 120 packages
+2020-10-01 12:00:10.000 [DeviceFarm] npm run build
+built
+2020-10-01 12:00:15.000 Finished [install] phase
+2020-10-01 12:00:15.000 Entering [test] phase
+2020-10-01 12:00:16.000 [DeviceFarm] echo start
+start
+2020-10-01 12:00:17.000 [DeviceFarm] npm test
+FAIL login.spec.js
+  expected true
+2020-10-01 12:00:47.000 [DeviceFarm] Command exited with code 1
+2020-10-01 12:00:48.000 Exiting [test] phase with exit code 1
+2020-10-01 12:00:48.000 Entering [post_test] phase
+2020-10-01 12:00:49.000 [DeviceFarm] echo done
+done
+`
+
+func TestParseTestSpecOutput(t *testing.T) {
+
+	type wantCommand struct {
+		command  string
+		exitCode string
+		duration time.Duration
+		line     int
+		output   string
+	}
+	type wantPhase struct {
+		name     string
+		exitCode string
+		duration time.Duration
+		commands []wantCommand
+	}
+
+	exitCode := func(code *int) string {
+		if code == nil {
+			return ""
+		}
+		return fmt.Sprint(*code)
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		want   []wantPhase
+	}{
+		{"phases", testSpecOutput, []wantPhase{
+			{"install", "", 15 * time.Second, []wantCommand{
+				{"npm install", "", 10 * time.Second, 2, "added 120 packages"},
+				{"npm run build", "", 5 * time.Second, 4, "built"},
+			}},
+			{"test", "1", 33 * time.Second, []wantCommand{
+				{"echo start", "", time.Second, 8, "start"},
+				{"npm test", "1", 31 * time.Second, 10, "FAIL login.spec.js|  expected true"},
+			}},
+			{"post_test", "", time.Second, []wantCommand{
+				{"echo done", "", 0, 16, "done"},
+			}},
+		}},
+		{"without timestamps", "Entering phase [pre_test]\n[DeviceFarm] adb devices\r\nList of devices\r\nExiting phase [pre_test] exit code: 0\n", []wantPhase{
+			{"pre_test", "0", 0, []wantCommand{{"adb devices", "", 0, 2, "List of devices"}}},
+		}},
+		{"command before any phase", "[DeviceFarm] echo\nnothing\n", []wantPhase{}},
+		{"long output", "Entering [test] phase\n[DeviceFarm] yes\n" + strings.Repeat("y\n", 30) + "last\n", []wantPhase{
+			{"test", "", 0, []wantCommand{{"yes", "", 0, 2, strings.Repeat("y|", specOutputTail-1) + "last"}}},
+		}},
+	}
+
+	for _, test := range tests {
+		phases, err := parseTestSpecOutput(strings.NewReader(test.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(phases) != len(test.want) {
+			t.Errorf("%s: got %d phases, want %d", test.name, len(phases), len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			p := phases[i]
+			if p.Name != want.name || exitCode(p.ExitCode) != want.exitCode || p.Duration != want.duration || len(p.Commands) != len(want.commands) {
+				t.Errorf("%s: phase %d is %s exit %q in %s with %d commands, want %s exit %q in %s with %d commands",
+					test.name, i, p.Name, exitCode(p.ExitCode), p.Duration, len(p.Commands), want.name, want.exitCode, want.duration, len(want.commands))
+				continue
+			}
+			for j, wantCmd := range want.commands {
+				c := p.Commands[j]
+				got := wantCommand{c.Command, exitCode(c.ExitCode), c.Duration, c.Line, strings.Join(c.Output, "|")}
+				if got != wantCmd {
+					t.Errorf("%s: phase %s command %d is %+v, want %+v", test.name, p.Name, j, got, wantCmd)
+				}
+			}
+		}
+	}
+}
+
+func TestFirstFailure(t *testing.T) {
+
+	command := func(name string, code *int) *specCommand {
+		return &specCommand{Command: name, ExitCode: code}
+	}
+
+	tests := []struct {
+		name   string
+		phases []*specPhase
+		want   string
+	}{
+		{"passed", []*specPhase{{Name: "test", ExitCode: intPointer(0), Commands: []*specCommand{command("npm test", intPointer(0))}}}, ""},
+		{"failed command", []*specPhase{
+			{Name: "install", Commands: []*specCommand{command("npm install", nil)}},
+			{Name: "test", ExitCode: intPointer(1), Commands: []*specCommand{command("echo", nil), command("npm test", intPointer(2)), command("echo", intPointer(3))}},
+		}, "phase test failed at `npm test` with exit code 2"},
+		{"failed phase", []*specPhase{{Name: "test", ExitCode: intPointer(1), Commands: []*specCommand{command("echo", nil), command("npm test", nil)}}}, "phase test failed at `npm test` with exit code 1"},
+		{"failed empty phase", []*specPhase{{Name: "pre_test", ExitCode: intPointer(127), Commands: []*specCommand{}}}, "phase pre_test failed with exit code 127"},
+		{"no phases", nil, ""},
+	}
+
+	for _, test := range tests {
+		phase, cmd := firstFailure(&jobNode{Phases: test.phases})
+		got := ""
+		if phase != nil {
+			got = describeFailure(phase, cmd)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSpecLineTime(t *testing.T) {
+
+	tests := []struct {
+		line string
+		time time.Time
+		text string
+	}{
+		{"2020-10-01 12:00:00.500 [DeviceFarm] ls", time.Date(2020, 10, 1, 12, 0, 0, 500000000, time.UTC), "[DeviceFarm] ls"},
+		{"[2020-10-01T12:00:00Z] done", time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC), "done"},
+		{"2020-10-01T12:00:00+02:00 done", time.Date(2020, 10, 1, 10, 0, 0, 0, time.UTC), "done"},
+		{"no time here", time.Time{}, "no time here"},
+	}
+
+	for _, test := range tests {
+		at, text := specLineTime(test.line)
+		if !at.Equal(test.time) || text != test.text {
+			t.Errorf("specLineTime(%q) = %s, %q, want %s, %q", test.line, at, text, test.time, test.text)
+		}
+	}
+}